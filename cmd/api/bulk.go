@@ -0,0 +1,241 @@
+// Filename: cmd/api/bulk.go
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"AWD_Quiz3.ryanarmstrong.net/internal/data"
+	"AWD_Quiz3.ryanarmstrong.net/internal/validator"
+)
+
+// The modes a bulk request can run in
+const (
+	bulkAtomic     = "atomic"      // every operation succeeds or none do
+	bulkBestEffort = "best_effort" // failed operations are skipped
+)
+
+// maxBulkOperations limits the size of a single bulk request
+const maxBulkOperations = 100
+
+// A bulkOperation is one create, update or delete in a bulk request. The
+// todo field holds the same fields as the single task endpoints
+type bulkOperation struct {
+	Op      string          `json:"op"`
+	ID      int64           `json:"id"`
+	Version *int32          `json:"version"`
+	Todo    json.RawMessage `json:"todo"`
+}
+
+// A bulkResult reports what happened to one operation, using the status
+// code that the single task endpoint would have sent
+type bulkResult struct {
+	Index  int         `json:"index"`
+	Op     string      `json:"op"`
+	ID     int64       `json:"id,omitempty"`
+	Status int         `json:"status"`
+	Todo   *data.Todo  `json:"todo,omitempty"`
+	Error  interface{} `json:"error,omitempty"`
+}
+
+// failed() reports if the operation did not go through
+func (result bulkResult) failed() bool {
+	return result.Status >= http.StatusBadRequest
+}
+
+// fail() marks the result as failed with a status code and message
+func (result bulkResult) fail(status int, message interface{}) bulkResult {
+	result.Status = status
+	result.Error = message
+	return result
+}
+
+// The bulkTodosHandler for the "POST /v1/todos/bulk" endpoint runs a batch
+// of operations in a single transaction. In atomic mode the first failure
+// rolls back the whole batch, in best_effort mode it is only reported
+func (app *application) bulkTodosHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Mode       string          `json:"mode"`
+		Operations []bulkOperation `json:"operations"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if input.Mode == "" {
+		input.Mode = bulkAtomic
+	}
+	// Initialize a validator
+	v := validator.New()
+	v.Check(validator.In(input.Mode, bulkAtomic, bulkBestEffort), "mode", "must be atomic or best_effort")
+	v.Check(len(input.Operations) > 0, "operations", "must contain at least one operation")
+	v.Check(len(input.Operations) <= maxBulkOperations, "operations", fmt.Sprintf("must not contain more than %d operations", maxBulkOperations))
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	tx, err := app.models.Todos.BeginTx(ctx)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+	todos := app.modelsFor(r).Todos.WithTx(tx)
+	userID := app.contextGetUser(r).ID
+	results := []bulkResult{}
+	failedAt := -1
+	for i, operation := range input.Operations {
+		result, err := app.runBulkOperation(todos, userID, operation)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		result.Index = i
+		results = append(results, result)
+		if result.failed() && input.Mode == bulkAtomic {
+			failedAt = i
+			break
+		}
+	}
+	// An atomic batch with a failure leaves nothing behind
+	if failedAt >= 0 {
+		for i := range results[:failedAt] {
+			results[i].Status = http.StatusFailedDependency
+			results[i].Todo = nil
+			results[i].Error = fmt.Sprintf("rolled back because operation %d failed", failedAt)
+		}
+		for i := failedAt + 1; i < len(input.Operations); i++ {
+			results = append(results, bulkResult{
+				Index:  i,
+				Op:     input.Operations[i].Op,
+				ID:     input.Operations[i].ID,
+				Status: http.StatusFailedDependency,
+				Error:  fmt.Sprintf("not attempted because operation %d failed", failedAt),
+			})
+		}
+		err = app.writeJSON(w, http.StatusUnprocessableEntity, envelope{"committed": false, "results": results}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = todos.CommitTx(tx)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"committed": true, "results": results}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The runBulkOperation() method applies a single operation with the same
+// checks as the single task endpoints. Only unexpected failures are
+// returned as errors; everything else is reported in the result
+func (app *application) runBulkOperation(todos data.TodoModel, userID int64, operation bulkOperation) (bulkResult, error) {
+	result := bulkResult{Op: operation.Op, ID: operation.ID}
+	v := validator.New()
+	switch operation.Op {
+	case "create":
+		var input createTodoInput
+		err := decodeBulkTodo(operation.Todo, &input)
+		if err != nil {
+			return result.fail(http.StatusBadRequest, err.Error()), nil
+		}
+		todo, err := app.newTodo(v, todos, userID, input)
+		if err != nil {
+			return result, err
+		}
+		if !v.Valid() {
+			return result.fail(http.StatusUnprocessableEntity, v.Errors), nil
+		}
+		err = todos.Insert(todo)
+		if err != nil {
+			return result, err
+		}
+		result.ID = todo.ID
+		result.Status = http.StatusCreated
+		result.Todo = todo
+	case "update":
+		todo, err := todos.Get(operation.ID, userID)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				return result.fail(http.StatusNotFound, "the requested resource could not be found"), nil
+			default:
+				return result, err
+			}
+		}
+		// Refuse to patch a newer version than the one the client has seen
+		if operation.Version != nil && *operation.Version != todo.Version {
+			return result.fail(http.StatusConflict, "unable to update the record due to an edit conflict, please try again"), nil
+		}
+		var input updateTodoInput
+		err = decodeBulkTodo(operation.Todo, &input)
+		if err != nil {
+			return result.fail(http.StatusBadRequest, err.Error()), nil
+		}
+		err = app.applyTodoUpdate(v, todos, todo, input)
+		if err != nil {
+			return result, err
+		}
+		if !v.Valid() {
+			return result.fail(http.StatusUnprocessableEntity, v.Errors), nil
+		}
+		err = todos.Update(todo)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrEditConflict):
+				return result.fail(http.StatusConflict, "unable to update the record due to an edit conflict, please try again"), nil
+			default:
+				return result, err
+			}
+		}
+		result.Status = http.StatusOK
+		result.Todo = todo
+	case "delete":
+		err := todos.Delete(operation.ID, userID, operation.Version)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				return result.fail(http.StatusNotFound, "the requested resource could not be found"), nil
+			case errors.Is(err, data.ErrEditConflict):
+				return result.fail(http.StatusConflict, "unable to delete the record due to an edit conflict, please try again"), nil
+			default:
+				return result, err
+			}
+		}
+		result.Status = http.StatusOK
+	default:
+		v.AddError("op", "must be create, update or delete")
+		return result.fail(http.StatusUnprocessableEntity, v.Errors), nil
+	}
+	return result, nil
+}
+
+// The decodeBulkTodo() function reads the todo field of an operation with
+// the same strictness as readJSON()
+func decodeBulkTodo(raw json.RawMessage, dst interface{}) error {
+	if len(raw) == 0 {
+		return errors.New("todo must be provided")
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(dst)
+	if err != nil {
+		return fmt.Errorf("todo is invalid: %w", err)
+	}
+	return nil
+}
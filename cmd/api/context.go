@@ -0,0 +1,56 @@
+// Filename: cmd/api/context.go
+
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"AWD_Quiz3.ryanarmstrong.net/internal/data"
+)
+
+// Define a custom type for our context keys
+type contextKey string
+
+// The keys used to store values in the request context
+const (
+	userContextKey      = contextKey("user")
+	requestIDContextKey = contextKey("request_id")
+)
+
+// The contextSetUser() method returns a copy of the request with the User added
+// to its context
+func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
+	ctx := context.WithValue(r.Context(), userContextKey, user)
+	return r.WithContext(ctx)
+}
+
+// The contextGetUser() method retrieves the User from the request context.
+// Every request passes through authenticate() so a missing User is a bug
+func (app *application) contextGetUser(r *http.Request) *data.User {
+	user, ok := r.Context().Value(userContextKey).(*data.User)
+	if !ok {
+		panic("missing user value in request context")
+	}
+	return user
+}
+
+// The contextSetRequestID() method returns a copy of the request with the
+// request ID added to its context
+func (app *application) contextSetRequestID(r *http.Request, requestID string) *http.Request {
+	ctx := context.WithValue(r.Context(), requestIDContextKey, requestID)
+	return r.WithContext(ctx)
+}
+
+// The contextGetRequestID() method retrieves the request ID from the
+// request context. It is empty if requestID() did not run
+func (app *application) contextGetRequestID(r *http.Request) string {
+	requestID, _ := r.Context().Value(requestIDContextKey).(string)
+	return requestID
+}
+
+// The modelsFor() method returns the models tagged with the ID of the
+// request so that changes can be traced back to it
+func (app *application) modelsFor(r *http.Request) data.Models {
+	return app.models.WithRequestID(app.contextGetRequestID(r))
+}
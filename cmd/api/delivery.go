@@ -0,0 +1,256 @@
+// Filename: cmd/api/delivery.go
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"AWD_Quiz3.ryanarmstrong.net/internal/data"
+)
+
+// Settings for the webhook delivery worker
+const (
+	webhookBatchSize    = 10               // deliveries attempted at once
+	webhookTimeout      = 10 * time.Second // time a receiver has to respond
+	webhookLease        = time.Minute      // time before a claimed delivery is due again
+	webhookPollInterval = 5 * time.Second  // how often to look for retries that are due
+	webhookFirstRetry   = 30 * time.Second // doubled after every failed attempt
+	webhookMaxRetry     = time.Hour
+)
+
+// webhookClient sends deliveries. It only connects to public addresses and
+// never through a proxy. Redirects are not followed, so a receiver has to
+// accept the delivery at the URL it registered
+var webhookClient = newWebhookClient()
+
+// The newWebhookClient() function builds the client that sends deliveries
+func newWebhookClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: webhookTimeout,
+		Control: checkWebhookAddress,
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   webhookTimeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// The checkWebhookAddress() function refuses to connect to an address that
+// is not public. It runs on the address actually being dialed, after the
+// host name was resolved, so a name that was public when the webhook was
+// registered cannot later be pointed at our own network
+func checkWebhookAddress(network string, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !publicIP(ip) {
+		return fmt.Errorf("refusing to connect to %s, which is not a public address", host)
+	}
+	return nil
+}
+
+// reservedNetworks are ranges that IsGlobalUnicast() lets through but that
+// are not reachable on the public internet
+var reservedNetworks = []*net.IPNet{
+	mustParseCIDR("0.0.0.0/8"),      // this network
+	mustParseCIDR("100.64.0.0/10"),  // carrier-grade NAT
+	mustParseCIDR("192.0.0.0/24"),   // IETF protocol assignments
+	mustParseCIDR("198.18.0.0/15"),  // benchmarking
+	mustParseCIDR("240.0.0.0/4"),    // reserved
+	mustParseCIDR("64:ff9b::/96"),   // NAT64, which can reach private IPv4
+	mustParseCIDR("64:ff9b:1::/48"), // local-use NAT64
+	mustParseCIDR("2001:db8::/32"),  // documentation
+}
+
+// The mustParseCIDR() function parses a network that is known to be valid
+func mustParseCIDR(cidr string) *net.IPNet {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+	return network
+}
+
+// The publicIP() function checks if an IP address is on the public
+// internet. Loopback, private, link-local, multicast and reserved
+// addresses are not
+func publicIP(ip net.IP) bool {
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return false
+	}
+	for _, network := range reservedNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// The publicHost() function checks if a host name or IP address only
+// resolves to public IP addresses
+func publicHost(ctx context.Context, host string) bool {
+	addresses, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil || len(addresses) == 0 {
+		return false
+	}
+	for _, address := range addresses {
+		if !publicIP(address.IP) {
+			return false
+		}
+	}
+	return true
+}
+
+// The signWebhook() function returns the signature of a delivery. It
+// covers the timestamp as well as the body so that an old delivery cannot
+// be replayed with a new timestamp
+func signWebhook(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// The webhookBackoff() function returns how long to wait before the next
+// attempt after the given attempt failed
+func webhookBackoff(attempt int) time.Duration {
+	backoff := webhookFirstRetry
+	for i := 1; i < attempt && backoff < webhookMaxRetry; i++ {
+		backoff *= 2
+	}
+	if backoff > webhookMaxRetry {
+		backoff = webhookMaxRetry
+	}
+	return backoff
+}
+
+// The deliverWebhooks() method runs in the background and attempts the
+// webhook deliveries that are due. New deliveries are queued along with
+// the events they carry, so the worker wakes up whenever there are new
+// events as well as every webhookPollInterval for retries
+func (app *application) deliverWebhooks() {
+	wake, unsubscribe := app.models.TodoEvents.Feed.Subscribe()
+	defer unsubscribe()
+	ticker := time.NewTicker(webhookPollInterval)
+	defer ticker.Stop()
+	for {
+		for {
+			jobs, err := app.models.Webhooks.ClaimDue(webhookBatchSize, webhookLease)
+			if err != nil {
+				app.logger.Println(err)
+				break
+			}
+			// Receivers are slow, so the batch is sent in parallel
+			var wg sync.WaitGroup
+			for _, job := range jobs {
+				wg.Add(1)
+				go func(job *data.WebhookJob) {
+					defer wg.Done()
+					app.attemptWebhookDelivery(job)
+				}(job)
+			}
+			wg.Wait()
+			if len(jobs) < webhookBatchSize {
+				break
+			}
+		}
+		select {
+		case <-wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// The attemptWebhookDelivery() method POSTs a delivery once and records
+// the outcome
+func (app *application) attemptWebhookDelivery(job *data.WebhookJob) {
+	attempt := app.tryWebhookDelivery(job)
+	err := app.models.Webhooks.RecordAttempt(job, attempt, app.config.webhooks.disableAfter)
+	if err != nil {
+		app.logger.Println(err)
+	}
+}
+
+// The tryWebhookDelivery() method POSTs a delivery once and returns the
+// outcome, scheduling a retry if there are attempts left
+func (app *application) tryWebhookDelivery(job *data.WebhookJob) data.WebhookAttempt {
+	start := time.Now()
+	attempt := data.WebhookAttempt{}
+	statusCode, err := app.postWebhook(job)
+	attempt.Duration = time.Since(start)
+	switch {
+	case err != nil:
+		attempt.Error = err.Error()
+	case statusCode < 200 || statusCode > 299:
+		attempt.StatusCode = &statusCode
+		attempt.Error = fmt.Sprintf("the receiver responded with %d %s", statusCode, http.StatusText(statusCode))
+	default:
+		attempt.StatusCode = &statusCode
+		attempt.Succeeded = true
+	}
+	if !attempt.Succeeded && job.Attempt < app.config.webhooks.maxAttempts {
+		retryAt := time.Now().Add(webhookBackoff(job.Attempt))
+		attempt.RetryAt = &retryAt
+	}
+	return attempt
+}
+
+// The postWebhook() method sends a delivery and returns the status code of
+// the response
+func (app *application) postWebhook(job *data.WebhookJob) (int, error) {
+	change, err := job.Event.Change()
+	if err != nil {
+		return 0, err
+	}
+	body, err := json.Marshal(change)
+	if err != nil {
+		return 0, err
+	}
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, job.Webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "todo-webhooks/"+version)
+	req.Header.Set("X-Webhook-ID", strconv.FormatInt(job.Webhook.ID, 10))
+	req.Header.Set("X-Webhook-Delivery", strconv.FormatInt(job.DeliveryID, 10))
+	req.Header.Set("X-Webhook-Event", job.Event.Type)
+	req.Header.Set("X-Webhook-Attempt", strconv.Itoa(job.Attempt))
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", signWebhook(job.Webhook.Secret, timestamp, body))
+	res, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	// Read a little of the body so that the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(res.Body, 64*1024))
+	return res.StatusCode, nil
+}
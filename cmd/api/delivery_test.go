@@ -0,0 +1,220 @@
+// Filename: cmd/api/delivery_test.go
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"AWD_Quiz3.ryanarmstrong.net/internal/data"
+	"AWD_Quiz3.ryanarmstrong.net/internal/validator"
+)
+
+func TestSignWebhook(t *testing.T) {
+	got := signWebhook("secret", "1700000000", []byte(`{"event_id":1}`))
+	want := "sha256=dd50adb138aae6c63e07ca88318bb0ffda13bcba001bd50739b8d68637c1aafe"
+	if got != want {
+		t.Errorf("got %s; want %s", got, want)
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{4, 4 * time.Minute},
+		{5, 8 * time.Minute},
+		{6, 16 * time.Minute},
+		{7, 32 * time.Minute},
+		{8, time.Hour},
+		{9, time.Hour},
+		{100, time.Hour},
+	}
+	for _, tt := range tests {
+		got := webhookBackoff(tt.attempt)
+		if got != tt.want {
+			t.Errorf("webhookBackoff(%d) = %s; want %s", tt.attempt, got, tt.want)
+		}
+	}
+}
+
+// The newTestDeliveryApp() function returns an application that can send
+// deliveries to receivers on the loopback interface, which the real client
+// refuses to connect to
+func newTestDeliveryApp(t *testing.T, maxAttempts int) *application {
+	t.Helper()
+	client := webhookClient
+	webhookClient = &http.Client{Timeout: webhookTimeout}
+	t.Cleanup(func() { webhookClient = client })
+	app := &application{logger: log.New(io.Discard, "", 0)}
+	app.config.webhooks.maxAttempts = maxAttempts
+	return app
+}
+
+// The newTestJob() function builds a claimed delivery of a created event
+func newTestJob(url string, attempt int) *data.WebhookJob {
+	return &data.WebhookJob{
+		DeliveryID: 7,
+		Attempt:    attempt,
+		Webhook:    &data.Webhook{ID: 3, URL: url, Secret: "secret"},
+		Event: &data.TodoEvent{
+			ID:        11,
+			CreatedAt: time.Now(),
+			TodoID:    5,
+			UserID:    1,
+			Type:      data.EventCreated,
+			NewValues: json.RawMessage(`{"id":5,"task":"Write tests","status":"todo","version":1}`),
+			Version:   1,
+		},
+	}
+}
+
+func TestTryWebhookDeliverySigned(t *testing.T) {
+	app := newTestDeliveryApp(t, 3)
+	var header http.Header
+	var body []byte
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Clone()
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	attempt := app.tryWebhookDelivery(newTestJob(receiver.URL, 1))
+	if !attempt.Succeeded || attempt.StatusCode == nil || *attempt.StatusCode != http.StatusNoContent {
+		t.Fatalf("got %+v; want a successful attempt", attempt)
+	}
+	if attempt.RetryAt != nil {
+		t.Errorf("a successful attempt was scheduled for a retry")
+	}
+	// The receiver can check the signature with the shared secret
+	want := signWebhook("secret", header.Get("X-Webhook-Timestamp"), body)
+	if got := header.Get("X-Webhook-Signature"); got != want {
+		t.Errorf("got signature %s; want %s", got, want)
+	}
+	for name, want := range map[string]string{
+		"X-Webhook-ID":       "3",
+		"X-Webhook-Delivery": "7",
+		"X-Webhook-Event":    "created",
+		"X-Webhook-Attempt":  "1",
+	} {
+		if got := header.Get(name); got != want {
+			t.Errorf("got %s %q; want %q", name, got, want)
+		}
+	}
+	var change data.TodoChange
+	err := json.Unmarshal(body, &change)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if change.EventID != 11 || change.TodoID != 5 || change.Todo == nil || change.Todo.Task != "Write tests" {
+		t.Errorf("got body %s; want the change for event 11", body)
+	}
+}
+
+func TestTryWebhookDeliveryGivesUp(t *testing.T) {
+	app := newTestDeliveryApp(t, 3)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer receiver.Close()
+
+	for attempt := 1; attempt <= 3; attempt++ {
+		before := time.Now()
+		result := app.tryWebhookDelivery(newTestJob(receiver.URL, attempt))
+		if result.Succeeded || result.StatusCode == nil || *result.StatusCode != http.StatusInternalServerError || result.Error == "" {
+			t.Fatalf("attempt %d: got %+v; want a failure with status 500", attempt, result)
+		}
+		switch {
+		case attempt < 3 && result.RetryAt == nil:
+			t.Errorf("attempt %d: no retry was scheduled", attempt)
+		case attempt < 3 && result.RetryAt.Before(before.Add(webhookBackoff(attempt))):
+			t.Errorf("attempt %d: retry at %s is sooner than the backoff", attempt, result.RetryAt)
+		case attempt == 3 && result.RetryAt != nil:
+			t.Errorf("attempt %d: got a retry after the last attempt", attempt)
+		}
+	}
+}
+
+func TestWebhookClientRefusesLoopback(t *testing.T) {
+	app := &application{logger: log.New(io.Discard, "", 0)}
+	app.config.webhooks.maxAttempts = 3
+	called := false
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer receiver.Close()
+
+	attempt := app.tryWebhookDelivery(newTestJob(receiver.URL, 1))
+	if attempt.Succeeded || called {
+		t.Fatal("the delivery reached a loopback address")
+	}
+	if !strings.Contains(attempt.Error, "not a public address") {
+		t.Errorf("got error %q; want the address to be refused", attempt.Error)
+	}
+}
+
+func TestPublicIP(t *testing.T) {
+	tests := []struct {
+		ip     string
+		public bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"fd00::1", false},
+		{"0.0.0.0", false},
+		{"::", false},
+		{"100.64.0.1", false},
+		{"224.0.0.1", false},
+		{"255.255.255.255", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:169.254.169.254", false},
+		{"64:ff9b::a9fe:a9fe", false},
+	}
+	for _, tt := range tests {
+		got := publicIP(net.ParseIP(tt.ip))
+		if got != tt.public {
+			t.Errorf("publicIP(%s) = %t; want %t", tt.ip, got, tt.public)
+		}
+	}
+}
+
+func TestValidateWebhookHostLiterals(t *testing.T) {
+	app := &application{}
+	// Literal addresses are checked without going to DNS
+	tests := []struct {
+		url   string
+		valid bool
+	}{
+		{"http://127.0.0.1/hook", false},
+		{"http://169.254.169.254/latest/meta-data", false},
+		{"https://[::1]:8443/hook", false},
+		{"https://93.184.216.34/hook", true},
+	}
+	for _, tt := range tests {
+		v := validator.New()
+		app.validateWebhookHost(context.Background(), v, &data.Webhook{URL: tt.url})
+		if v.Valid() != tt.valid {
+			t.Errorf("validateWebhookHost(%s) gave errors %v; want valid %t", tt.url, v.Errors, tt.valid)
+		}
+	}
+}
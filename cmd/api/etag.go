@@ -0,0 +1,153 @@
+// Filename: cmd/api/etag.go
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"AWD_Quiz3.ryanarmstrong.net/internal/data"
+)
+
+// The todoETag() function derives a strong ETag from the id and version of
+// a Task. The version changes every time the Task is written. Embedded
+// subtasks are written on their own, so once they are embedded their ids
+// and versions are hashed into the ETag as well
+func todoETag(todo *data.Todo) string {
+	if todo.Subtasks == nil {
+		return fmt.Sprintf(`"%d-%d"`, todo.ID, todo.Version)
+	}
+	hash := sha256.New()
+	for _, subtask := range todo.Subtasks {
+		fmt.Fprintf(hash, "%d-%d;", subtask.ID, subtask.Version)
+	}
+	return fmt.Sprintf(`"%d-%d-%s"`, todo.ID, todo.Version, hex.EncodeToString(hash.Sum(nil)[:8]))
+}
+
+// The versionFromETag() function reads the version back out of an ETag
+// made by todoETag(). It fails if the ETag belongs to a different Task
+func versionFromETag(etag string, id int64) (int32, bool) {
+	etag = strings.TrimSpace(etag)
+	if len(etag) < 2 || !strings.HasPrefix(etag, `"`) || !strings.HasSuffix(etag, `"`) {
+		return 0, false
+	}
+	idPart, versionPart, found := strings.Cut(etag[1:len(etag)-1], "-")
+	if !found || idPart != strconv.FormatInt(id, 10) {
+		return 0, false
+	}
+	// Drop the hash of the subtasks
+	versionPart, _, _ = strings.Cut(versionPart, "-")
+	version, err := strconv.ParseInt(versionPart, 10, 32)
+	if err != nil || version < 1 {
+		return 0, false
+	}
+	return int32(version), true
+}
+
+// The listingETag() function derives a weak ETag from the body of a listing
+func listingETag(env envelope) (string, error) {
+	js, err := json.Marshal(env)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(js)
+	return `W/"` + hex.EncodeToString(hash[:16]) + `"`, nil
+}
+
+// The etagMatches() function checks an If-Match or If-None-Match header
+// against an ETag. When weak is true the W/ prefix is ignored on both sides
+func etagMatches(header string, etag string, weak bool) bool {
+	if weak {
+		etag = strings.TrimPrefix(etag, "W/")
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+		} else if strings.HasPrefix(candidate, "W/") || strings.HasPrefix(etag, "W/") {
+			// Strong comparison never matches a weak ETag
+			continue
+		}
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// The notModified() method checks the If-None-Match header. When the client
+// already holds the current representation it sends 304 Not Modified and
+// returns true
+func (app *application) notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	header := r.Header.Get("If-None-Match")
+	if header == "" || !etagMatches(header, etag, true) {
+		return false
+	}
+	w.Header().Set("ETag", etag)
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// The versionMatches() function checks an If-Match header against the
+// version of a Task. Only the version counts, so any ETag the Task was
+// served with matches until the Task itself is written
+func versionMatches(header string, todo *data.Todo) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		version, ok := versionFromETag(candidate, todo.ID)
+		if ok && version == todo.Version {
+			return true
+		}
+	}
+	return false
+}
+
+// The preconditionFailed() method checks the If-Match header. When the
+// client's copy of the Task is out of date it sends 412 Precondition
+// Failed and returns true
+func (app *application) preconditionFailed(w http.ResponseWriter, r *http.Request, todo *data.Todo) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" || versionMatches(header, todo) {
+		return false
+	}
+	app.preconditionFailedResponse(w, r)
+	return true
+}
+
+// The writeListingJSON() method writes a listing with a weak ETag, or a 304
+// Not Modified response when the client's copy is still current
+func (app *application) writeListingJSON(w http.ResponseWriter, r *http.Request, env envelope) error {
+	return app.writeProjectedListingJSON(w, r, nil, env)
+}
+
+// The writeProjectedListingJSON() method reshapes the tasks in a listing
+// with a projection and then writes it like writeListingJSON(). The ETag
+// is taken from the projected listing, so that each shape of the listing
+// has an ETag of its own
+func (app *application) writeProjectedListingJSON(w http.ResponseWriter, r *http.Request, p *projection, env envelope) error {
+	env, err := p.apply(env)
+	if err != nil {
+		return err
+	}
+	etag, err := listingETag(env)
+	if err != nil {
+		return err
+	}
+	if app.notModified(w, r, etag) {
+		return nil
+	}
+	headers := make(http.Header)
+	headers.Set("ETag", etag)
+	return app.writeJSON(w, http.StatusOK, env, headers)
+}
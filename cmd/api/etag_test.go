@@ -0,0 +1,90 @@
+// Filename: cmd/api/etag_test.go
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"AWD_Quiz3.ryanarmstrong.net/internal/data"
+)
+
+func TestTodoETagCoversSubtasks(t *testing.T) {
+	todo := &data.Todo{ID: 7, Version: 3}
+	if etag := todoETag(todo); etag != `"7-3"` {
+		t.Errorf("got %s; want \"7-3\"", etag)
+	}
+	todo.Subtasks = []*data.Todo{{ID: 8, Version: 1}, {ID: 9, Version: 2}}
+	before := todoETag(todo)
+	// Finishing a subtask changes the ETag of its parent
+	todo.Subtasks[1].Version = 3
+	after := todoETag(todo)
+	if before == after {
+		t.Errorf("the ETag %s did not change with a subtask", before)
+	}
+	// Every ETag still names the version of the Task itself
+	for _, etag := range []string{before, after} {
+		version, ok := versionFromETag(etag, todo.ID)
+		if !ok || version != todo.Version {
+			t.Errorf("versionFromETag(%s) = %d, %t; want %d, true", etag, version, ok, todo.Version)
+		}
+		if !versionMatches(etag, todo) {
+			t.Errorf("If-Match %s did not match version %d", etag, todo.Version)
+		}
+	}
+}
+
+func TestVersionMatches(t *testing.T) {
+	todo := &data.Todo{ID: 7, Version: 3}
+	tests := []struct {
+		header string
+		match  bool
+	}{
+		{`"7-3"`, true},
+		{`"7-3-0123456789abcdef"`, true},
+		{`"7-2", "7-3"`, true},
+		{`*`, true},
+		{`"7-2"`, false},
+		{`"8-3"`, false},
+		{`W/"7-3"`, false},
+		{`"7-x"`, false},
+	}
+	for _, tt := range tests {
+		if got := versionMatches(tt.header, todo); got != tt.match {
+			t.Errorf("versionMatches(%s) = %t; want %t", tt.header, got, tt.match)
+		}
+	}
+}
+
+// A listing cut down to fewer fields has a different body, so it must
+// have a different ETag
+func TestProjectedListingETag(t *testing.T) {
+	app := &application{}
+	env := envelope{"todos": []*data.Todo{{ID: 7, Task: "Write report", Version: 3}}}
+	etags := map[string]bool{}
+	for _, p := range []*projection{nil, {fields: []string{"id"}}, {exclude: []string{"task"}}, {links: true}} {
+		rr := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/v1/todos", nil)
+		err := app.writeProjectedListingJSON(rr, r, p, env)
+		if err != nil {
+			t.Fatal(err)
+		}
+		projected, err := p.apply(env)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := listingETag(projected)
+		if err != nil {
+			t.Fatal(err)
+		}
+		etag := rr.Header().Get("ETag")
+		if etag != want {
+			t.Errorf("got ETag %s; want %s", etag, want)
+		}
+		etags[etag] = true
+	}
+	if len(etags) != 4 {
+		t.Errorf("got %d different ETags for 4 projections; want 4", len(etags))
+	}
+}
@@ -0,0 +1,118 @@
+// Filename: cmd/api/events.go
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// How often an idle event stream sends a comment to keep proxies from
+// closing it. The todo_events table is read again at the same time in case
+// a wake-up was missed
+const eventStreamHeartbeat = 15 * time.Second
+
+// How many events are read from the todo_events table at a time
+const eventStreamBatchSize = 100
+
+// The todoEventsHandler for the "GET /v1/todos/events" endpoint streams
+// changes to the user's tasks as Server-Sent Events. Each event has the
+// position it was committed at as its id, so a client that reconnects
+// with a Last-Event-ID header picks up where it left off
+func (app *application) todoEventsHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		app.serverErrorResponse(w, r, fmt.Errorf("streaming is not supported by %T", w))
+		return
+	}
+	// Resume after the last event the client saw, or start from now
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("last_event_id")
+	}
+	var afterPosition int64
+	if lastEventID != "" {
+		position, err := strconv.ParseInt(lastEventID, 10, 64)
+		if err != nil || position < 0 {
+			app.badRequestResponse(w, r, errors.New("the Last-Event-ID must be a positive integer"))
+			return
+		}
+		afterPosition = position
+	} else {
+		position, err := app.models.TodoEvents.LatestPosition()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		afterPosition = position
+	}
+	// Subscribe before reading the backlog so that nothing committed in
+	// between is missed
+	wake, unsubscribe := app.models.TodoEvents.Feed.Subscribe()
+	defer unsubscribe()
+	// The stream is open for far longer than the server's WriteTimeout
+	err := http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", (3 * time.Second).Milliseconds())
+	flusher.Flush()
+	userID := app.contextGetUser(r).ID
+	heartbeat := time.NewTicker(eventStreamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		afterPosition, err = app.sendTodoEvents(w, userID, afterPosition)
+		if err != nil {
+			// The response has started, so all we can do is log and hang up
+			app.logger.Println(err)
+			return
+		}
+		flusher.Flush()
+		select {
+		case <-r.Context().Done():
+			return
+		case <-wake:
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+		}
+	}
+}
+
+// The sendTodoEvents() method writes every event for a user committed
+// after afterPosition to the stream and returns the position of the last
+// one written
+func (app *application) sendTodoEvents(w http.ResponseWriter, userID int64, afterPosition int64) (int64, error) {
+	for {
+		events, err := app.models.TodoEvents.GetAllAfter(userID, afterPosition, eventStreamBatchSize)
+		if err != nil {
+			return afterPosition, err
+		}
+		for _, event := range events {
+			change, err := event.Change()
+			if err != nil {
+				return afterPosition, err
+			}
+			js, err := json.Marshal(change)
+			if err != nil {
+				return afterPosition, err
+			}
+			_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Position, event.Type, js)
+			if err != nil {
+				return afterPosition, err
+			}
+			afterPosition = event.Position
+		}
+		if len(events) < eventStreamBatchSize {
+			return afterPosition, nil
+		}
+	}
+}
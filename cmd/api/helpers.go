@@ -11,6 +11,7 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"AWD_Quiz3.ryanarmstrong.net/internal/validator"
 	"github.com/julienschmidt/httprouter"
@@ -50,6 +51,16 @@ func (app *application) writeJSON(w http.ResponseWriter, status int, data envelo
 	return nil
 }
 
+// The writeProjectedJSON() method reshapes the tasks in an envelope with a
+// projection before writing it. A nil projection writes the envelope as is
+func (app *application) writeProjectedJSON(w http.ResponseWriter, status int, p *projection, data envelope, headers http.Header) error {
+	data, err := p.apply(data)
+	if err != nil {
+		return err
+	}
+	return app.writeJSON(w, status, data, headers)
+}
+
 func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst interface{}) error {
 	// Use http.MaxBytesReader() to limit the size of the request body to
 	// 1 MB 2^20
@@ -146,3 +157,79 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	}
 	return intValue
 }
+
+// The readInt32() method converts a string value from the query string to a
+// 32-bit integer. A value that is not an integer or does not fit in 32 bits
+// adds a validation error instead of wrapping around
+func (app *application) readInt32(qs url.Values, key string, defaultValue int32, v *validator.Validator) int32 {
+	// Get the value
+	value := qs.Get(key)
+	if value == "" {
+		return defaultValue
+	}
+	intValue, err := strconv.ParseInt(value, 10, 32)
+	if err != nil {
+		switch {
+		case errors.Is(err, strconv.ErrRange):
+			v.AddError(key, "must be between -2147483648 and 2147483647")
+		default:
+			v.AddError(key, "must be an integer value")
+		}
+		return defaultValue
+	}
+	return int32(intValue)
+}
+
+// The readFloat() method converts a string value from the query string to a float
+// If the value cannot be converted then a validation error is added to
+// the validation errors map
+func (app *application) readFloat(qs url.Values, key string, defaultValue float64, v *validator.Validator) float64 {
+	// Get the value
+	value := qs.Get(key)
+	if value == "" {
+		return defaultValue
+	}
+	// Perform the conversion to a float
+	floatValue, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		v.AddError(key, "must be a number")
+		return defaultValue
+	}
+	return floatValue
+}
+
+// The readBool() method converts a string value from the query string to a boolean
+// If the value cannot be converted then a validation error is added to
+// the validation errors map
+func (app *application) readBool(qs url.Values, key string, defaultValue bool, v *validator.Validator) bool {
+	// Get the value
+	value := qs.Get(key)
+	if value == "" {
+		return defaultValue
+	}
+	// Perform the conversion to a boolean
+	boolValue, err := strconv.ParseBool(value)
+	if err != nil {
+		v.AddError(key, "must be a boolean value")
+		return defaultValue
+	}
+	return boolValue
+}
+
+// The readTime() method converts an RFC 3339 string value from the query string
+// to a time. If no matching key is found then nil is returned. If the value cannot
+// be converted then a validation error is added to the validation errors map
+func (app *application) readTime(qs url.Values, key string, v *validator.Validator) *time.Time {
+	// Get the value
+	value := qs.Get(key)
+	if value == "" {
+		return nil
+	}
+	// Perform the conversion to a time
+	timeValue, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		v.AddError(key, "must be an RFC 3339 timestamp")
+		return nil
+	}
+	return &timeValue
+}
@@ -0,0 +1,36 @@
+// Filename: cmd/api/helpers_test.go
+
+package main
+
+import (
+	"net/url"
+	"testing"
+
+	"AWD_Quiz3.ryanarmstrong.net/internal/validator"
+)
+
+func TestReadInt32(t *testing.T) {
+	app := &application{}
+	tests := []struct {
+		value string
+		want  int32
+		error string
+	}{
+		{"", 7, ""},
+		{"12", 12, ""},
+		{"-3", -3, ""},
+		{"2147483647", 2147483647, ""},
+		{"2147483648", 7, "must be between -2147483648 and 2147483647"},
+		{"4294967297", 7, "must be between -2147483648 and 2147483647"},
+		{"-2147483649", 7, "must be between -2147483648 and 2147483647"},
+		{"1.5", 7, "must be an integer value"},
+		{"abc", 7, "must be an integer value"},
+	}
+	for _, tt := range tests {
+		v := validator.New()
+		got := app.readInt32(url.Values{"version": {tt.value}}, "version", 7, v)
+		if got != tt.want || v.Errors["version"] != tt.error {
+			t.Errorf("readInt32(%q) = %d with error %q; want %d with error %q", tt.value, got, v.Errors["version"], tt.want, tt.error)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+// Filename: cmd/api/hub.go
+
+package main
+
+import (
+	"sync"
+	"time"
+
+	"AWD_Quiz3.ryanarmstrong.net/internal/data"
+	"github.com/gorilla/websocket"
+)
+
+// A hub keeps track of the open WebSocket connections so that changes to
+// tasks can be handed to the connections of the user who owns them
+type hub struct {
+	mu      sync.Mutex
+	clients map[*wsClient]struct{}
+}
+
+// newHub() allows us to create a new hub
+func newHub() *hub {
+	return &hub{clients: make(map[*wsClient]struct{})}
+}
+
+// The register() method adds a connection to the hub
+func (h *hub) register(c *wsClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.clients[c] = struct{}{}
+}
+
+// The unregister() method removes a connection from the hub
+func (h *hub) unregister(c *wsClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.clients, c)
+}
+
+// The clientsFor() method returns the connections that belong to a user
+func (h *hub) clientsFor(userID int64) []*wsClient {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	clients := []*wsClient{}
+	for c := range h.clients {
+		if c.user.ID == userID {
+			clients = append(clients, c)
+		}
+	}
+	return clients
+}
+
+// The empty() method reports if there are no open connections
+func (h *hub) empty() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.clients) == 0
+}
+
+// The broadcastTodoEvents() method runs in the background and hands every
+// new row in the todo_events table to the hub. The table is read once per
+// wake-up no matter how many connections are open
+func (app *application) broadcastTodoEvents() {
+	wake, unsubscribe := app.models.TodoEvents.Feed.Subscribe()
+	defer unsubscribe()
+	// Check the table now and then in case a wake-up was missed
+	ticker := time.NewTicker(eventStreamHeartbeat)
+	defer ticker.Stop()
+	var afterPosition int64
+	started := false
+	for {
+		var err error
+		switch {
+		case !started || app.hub.empty():
+			// Nobody is listening, so skip straight to the newest event
+			afterPosition, err = app.models.TodoEvents.LatestPosition()
+			started = err == nil
+		default:
+			afterPosition, err = app.dispatchTodoEvents(afterPosition)
+		}
+		if err != nil {
+			app.logger.Println(err)
+		}
+		select {
+		case <-wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// The dispatchTodoEvents() method sends every event committed after
+// afterPosition to the connections of the user who owns the task, and
+// returns the position of the last event sent. Like the event stream, it
+// follows commit order so that an event which commits late is not skipped
+func (app *application) dispatchTodoEvents(afterPosition int64) (int64, error) {
+	for {
+		events, err := app.models.TodoEvents.GetAllSince(afterPosition, eventStreamBatchSize)
+		if err != nil {
+			return afterPosition, err
+		}
+		for _, event := range events {
+			afterPosition = event.Position
+			clients := app.hub.clientsFor(event.UserID)
+			if len(clients) == 0 {
+				continue
+			}
+			change, err := newWSChange(event)
+			if err != nil {
+				app.logger.Println(err)
+				continue
+			}
+			for _, c := range clients {
+				// A connection that cannot keep up is closed rather than
+				// allowed to hold up everybody else
+				if !c.deliver(change) {
+					c.close(websocket.CloseTryAgainLater, "too slow to keep up with changes")
+				}
+			}
+		}
+		if len(events) < eventStreamBatchSize {
+			return afterPosition, nil
+		}
+	}
+}
+
+// A wsChange is an event along with the Task before and after it
+type wsChange struct {
+	*data.TodoChange
+	old *data.Todo // nil for a created Task
+}
+
+// newWSChange() decodes the snapshots held by an event
+func newWSChange(event *data.TodoEvent) (*wsChange, error) {
+	change, err := event.Change()
+	if err != nil {
+		return nil, err
+	}
+	old, err := event.OldTodo()
+	if err != nil {
+		return nil, err
+	}
+	return &wsChange{TodoChange: change, old: old}, nil
+}
@@ -0,0 +1,196 @@
+// Filename: cmd/api/list.go
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"AWD_Quiz3.ryanarmstrong.net/internal/data"
+	"AWD_Quiz3.ryanarmstrong.net/internal/validator"
+)
+
+// createListHandler for the "POST /v1/lists" endpoint
+func (app *application) createListHandler(w http.ResponseWriter, r *http.Request) {
+	// Our target decode destination
+	var input struct {
+		Name string `json:"name"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	list := &data.List{
+		UserID: app.contextGetUser(r).ID,
+		Name:   input.Name,
+	}
+	// Initialize a new Validator instance
+	v := validator.New()
+	if data.ValidateList(v, list); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	// Create the List
+	err = app.models.Lists.Insert(list)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	// Create a Location header for the newly created resource
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/lists/%d", list.ID))
+	err = app.writeJSON(w, http.StatusCreated, envelope{"list": list}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showListHandler for the "GET /v1/lists/:id" endpoint
+func (app *application) showListHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	// Fetch the specific list
+	list, err := app.models.Lists.Get(id, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"list": list}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateListHandler for the "PATCH /v1/lists/:id" endpoint
+func (app *application) updateListHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	// Fetch the original record from the database
+	list, err := app.models.Lists.Get(id, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	// A nil field was not supplied by the client
+	var input struct {
+		Name *string `json:"name"`
+	}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if input.Name != nil {
+		list.Name = *input.Name
+	}
+	// Initialize a new Validator instance
+	v := validator.New()
+	if data.ValidateList(v, list); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.Lists.Update(list)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"list": list}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteListHandler for the "DELETE /v1/lists/:id" endpoint. The "todos"
+// query parameter chooses what happens to the tasks in the list: "cascade"
+// deletes them and "inbox" (the default) moves them to the inbox list
+func (app *application) deleteListHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	// Initialize a validator
+	v := validator.New()
+	mode := app.readString(r.URL.Query(), "todos", "inbox")
+	v.Check(validator.In(mode, "cascade", "inbox"), "todos", "must be cascade or inbox")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	// Fetch the list so that we can protect the inbox
+	list, err := app.models.Lists.Get(id, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if list.IsInbox {
+		app.badRequestResponse(w, r, errors.New("the inbox list cannot be deleted"))
+		return
+	}
+	err = app.modelsFor(r).Lists.Delete(list.ID, list.UserID, mode == "cascade")
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "list successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The listListsHandler allows the client to see all of their lists
+func (app *application) listListsHandler(w http.ResponseWriter, r *http.Request) {
+	lists, err := app.models.Lists.GetAll(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeListingJSON(w, r, envelope{"lists": lists})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The validateListOwnership() method checks that a list id supplied by the
+// client refers to one of the caller's lists
+func (app *application) validateListOwnership(v *validator.Validator, listID int64, userID int64) error {
+	_, err := app.models.Lists.Get(listID, userID)
+	if errors.Is(err, data.ErrRecordNotFound) {
+		v.AddError("list_id", "must refer to an existing list")
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,55 @@
+// Filename: cmd/api/listener.go
+
+package main
+
+import (
+	"time"
+
+	"AWD_Quiz3.ryanarmstrong.net/internal/data"
+	"github.com/lib/pq"
+)
+
+// How long the listener waits before reconnecting after losing its
+// connection. The wait doubles on each failure up to the maximum
+const (
+	listenerMinReconnect = 10 * time.Second
+	listenerMaxReconnect = time.Minute
+)
+
+// How often an idle listener checks that its connection is still alive
+const listenerPingPeriod = 90 * time.Second
+
+// The listenForTodoChanges() method runs in the background and wakes up
+// this server's subscribers whenever any server commits a change to a
+// task. The changes themselves are read from the todo_events table, so a
+// notification lost while reconnecting only delays them
+func (app *application) listenForTodoChanges() {
+	listener := pq.NewListener(app.config.db.dsn, listenerMinReconnect, listenerMaxReconnect, func(event pq.ListenerEventType, err error) {
+		switch event {
+		case pq.ListenerEventConnectionAttemptFailed, pq.ListenerEventDisconnected:
+			app.logger.Printf("todo change listener: %v", err)
+		case pq.ListenerEventReconnected:
+			app.logger.Println("todo change listener reconnected")
+		}
+	})
+	defer listener.Close()
+	// Listen() waits until the listener has connected, and only fails if
+	// the server refuses the LISTEN
+	err := listener.Listen(data.TodoChangesChannel)
+	if err != nil {
+		app.logger.Printf("todo change listener: %v", err)
+		return
+	}
+	ping := time.NewTicker(listenerPingPeriod)
+	defer ping.Stop()
+	for {
+		select {
+		// A nil notification means the connection was re-established and
+		// notifications may have been missed, so wake everyone anyway
+		case <-listener.Notify:
+			app.models.TodoEvents.Feed.Publish()
+		case <-ping.C:
+			go listener.Ping()
+		}
+	}
+}
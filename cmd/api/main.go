@@ -29,6 +29,18 @@ type config struct {
 		maxIdleConns int
 		maxIdleTime  string
 	}
+	trash struct {
+		retention time.Duration // zero keeps deleted tasks forever
+	}
+	webhooks struct {
+		maxAttempts  int // attempts at a delivery before giving up on it
+		disableAfter int // failed attempts in a row before a webhook is disabled
+	}
+	outbox struct {
+		sinks     string        // comma separated: log, http
+		httpURL   string        // where the http sink POSTs messages
+		retention time.Duration // zero keeps dispatched messages forever
+	}
 }
 
 // Dependency Injection
@@ -36,6 +48,7 @@ type application struct {
 	config config
 	logger *log.Logger
 	models data.Models
+	hub    *hub // the open WebSocket connections
 }
 
 func main() {
@@ -47,6 +60,12 @@ func main() {
 	flag.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
 	flag.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
 	flag.StringVar(&cfg.db.maxIdleTime, "db-max-idle-time", "15m", "PostgreSQL max idle connections time")
+	flag.DurationVar(&cfg.trash.retention, "trash-retention", 30*24*time.Hour, "How long deleted tasks stay in the trash (0 keeps them forever)")
+	flag.IntVar(&cfg.webhooks.maxAttempts, "webhook-max-attempts", 8, "Attempts at a webhook delivery before giving up")
+	flag.IntVar(&cfg.webhooks.disableAfter, "webhook-disable-after", 20, "Failed webhook attempts in a row before the webhook is disabled")
+	flag.StringVar(&cfg.outbox.sinks, "outbox-sinks", "log", "Comma separated sinks to relay outbox messages to (log, http)")
+	flag.StringVar(&cfg.outbox.httpURL, "outbox-http-url", "", "URL that the http outbox sink POSTs messages to")
+	flag.DurationVar(&cfg.outbox.retention, "outbox-retention", 7*24*time.Hour, "How long dispatched outbox messages are kept (0 keeps them forever)")
 	flag.Parse()
 	// Create a logger
 	logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
@@ -64,11 +83,31 @@ func main() {
 		config: cfg,
 		logger: logger,
 		models: data.NewModels(db),
+		hub:    newHub(),
+	}
+	// Hear about changes to tasks made by every server, and hand them to
+	// the open WebSocket connections
+	go app.listenForTodoChanges()
+	go app.broadcastTodoEvents()
+	// Send queued webhook deliveries
+	go app.deliverWebhooks()
+	// Publish the changes recorded in the outbox
+	sinks, err := app.outboxSinks()
+	if err != nil {
+		logger.Fatal(err)
+	}
+	if len(sinks) > 0 {
+		go app.relayOutbox(sinks)
+	}
+	// Empty the trash in the background
+	if cfg.trash.retention > 0 {
+		go app.purgeExpiredTrash()
 	}
 	// Create our new servemux
 	mux := http.NewServeMux()
 	mux.HandleFunc("/v1/healthcheck", app.healthcheckHandler)
-	// Create our HTTP server
+	// Create our HTTP server. Event streams lift the WriteTimeout for
+	// their own connections, and WebSockets manage their own deadlines
 	srv := &http.Server{
 		Addr:         fmt.Sprintf(":%d", cfg.port),
 		Handler:      app.routes(),
@@ -0,0 +1,164 @@
+// Filename: cmd/api/outbox.go
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"AWD_Quiz3.ryanarmstrong.net/internal/data"
+)
+
+// Settings for the outbox relay
+const (
+	outboxBatchSize     = 50              // messages claimed at a time
+	outboxPollInterval  = 5 * time.Second // how often to look for messages without a wake-up
+	outboxSinkTimeout   = 10 * time.Second
+	outboxPurgeInterval = time.Hour
+)
+
+// An outboxSink publishes outbox messages somewhere outside the API. A
+// message is only marked as dispatched once every sink has accepted it,
+// so a sink may see a message more than once and should use its id to
+// ignore repeats
+type outboxSink interface {
+	Name() string
+	Send(ctx context.Context, message *data.OutboxMessage) error
+}
+
+// A logSink writes each message to the log as a single JSON object
+type logSink struct {
+	logger *log.Logger
+}
+
+func (s *logSink) Name() string {
+	return "log"
+}
+
+func (s *logSink) Send(ctx context.Context, message *data.OutboxMessage) error {
+	js, err := json.Marshal(envelope{"level": "INFO", "msg": "outbox message", "message": message})
+	if err != nil {
+		return err
+	}
+	s.logger.Println(string(js))
+	return nil
+}
+
+// An httpSink POSTs each message to a URL. Any 2xx response means the
+// message was accepted
+type httpSink struct {
+	url    string
+	client *http.Client
+}
+
+func (s *httpSink) Name() string {
+	return "http"
+}
+
+func (s *httpSink) Send(ctx context.Context, message *data.OutboxMessage) error {
+	body, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "todo-outbox/"+version)
+	req.Header.Set("Idempotency-Key", strconv.FormatInt(message.ID, 10))
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	// Read a little of the body so that the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(res.Body, 64*1024))
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("the receiver responded with %d %s", res.StatusCode, http.StatusText(res.StatusCode))
+	}
+	return nil
+}
+
+// The outboxSinks() method builds the sinks named in the configuration
+func (app *application) outboxSinks() ([]outboxSink, error) {
+	sinks := []outboxSink{}
+	for _, name := range strings.Split(app.config.outbox.sinks, ",") {
+		switch strings.TrimSpace(name) {
+		case "":
+		case "log":
+			sinks = append(sinks, &logSink{logger: app.logger})
+		case "http":
+			if app.config.outbox.httpURL == "" {
+				return nil, fmt.Errorf("the http outbox sink needs -outbox-http-url")
+			}
+			sinks = append(sinks, &httpSink{url: app.config.outbox.httpURL, client: &http.Client{Timeout: outboxSinkTimeout}})
+		default:
+			return nil, fmt.Errorf("unknown outbox sink %q", name)
+		}
+	}
+	return sinks, nil
+}
+
+// The relayOutbox() method runs in the background and hands the messages
+// in the outbox to the sinks in the order they were committed. A message
+// that a sink refuses holds up the ones behind it until it goes through
+func (app *application) relayOutbox(sinks []outboxSink) {
+	wake, unsubscribe := app.models.TodoEvents.Feed.Subscribe()
+	defer unsubscribe()
+	ticker := time.NewTicker(outboxPollInterval)
+	defer ticker.Stop()
+	// A claim has to last long enough for every sink to time out on one
+	// message, with time to spare
+	lease := time.Duration(len(sinks)+1) * outboxSinkTimeout
+	purged := time.Now()
+	for {
+		for {
+			count, err := app.models.Outbox.Relay(outboxBatchSize, lease, func(message *data.OutboxMessage) error {
+				return app.sendToSinks(sinks, message)
+			})
+			if err != nil {
+				app.logger.Println(err)
+				break
+			}
+			if count < outboxBatchSize {
+				break
+			}
+		}
+		// Dispatched messages are only kept for a while
+		if app.config.outbox.retention > 0 && time.Since(purged) >= outboxPurgeInterval {
+			_, err := app.models.Outbox.DeleteDispatched(app.config.outbox.retention)
+			if err != nil {
+				app.logger.Println(err)
+			}
+			purged = time.Now()
+		}
+		select {
+		case <-wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// The sendToSinks() method hands a message to every sink in turn
+func (app *application) sendToSinks(sinks []outboxSink, message *data.OutboxMessage) error {
+	for _, sink := range sinks {
+		// Create a context
+		ctx, cancel := context.WithTimeout(context.Background(), outboxSinkTimeout)
+		err := sink.Send(ctx, message)
+		// Cleanup to prevent memory leaks
+		cancel()
+		if err != nil {
+			return fmt.Errorf("outbox sink %s could not send message %d: %w", sink.Name(), message.ID, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+// Filename: cmd/api/projection.go
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+
+	"AWD_Quiz3.ryanarmstrong.net/internal/data"
+	"AWD_Quiz3.ryanarmstrong.net/internal/validator"
+)
+
+// The envelope keys that hold tasks, either one or a slice of them
+var projectedKeys = []string{"todo", "todos", "subtasks"}
+
+// A projection describes the shape a client wants tasks returned in
+type projection struct {
+	fields  []string // keep only these fields, empty for every field
+	exclude []string // drop these fields
+	links   bool     // add a _links section to every task
+}
+
+// The readProjection() method reads the "fields", "exclude" and "links"
+// query parameters. It returns nil when the client asked for the usual
+// output
+func (app *application) readProjection(qs url.Values, v *validator.Validator) *projection {
+	p := &projection{
+		fields:  app.readCSV(qs, "fields", []string{}),
+		exclude: app.readCSV(qs, "exclude", []string{}),
+		links:   app.readBool(qs, "links", false, v),
+	}
+	v.Check(len(p.fields) == 0 || len(p.exclude) == 0, "fields", "must not be used together with exclude")
+	known := data.TodoFields()
+	for _, field := range p.fields {
+		v.Check(validator.In(field, known...), "fields", fmt.Sprintf("%q is not a task field", field))
+	}
+	for _, field := range p.exclude {
+		v.Check(validator.In(field, known...), "exclude", fmt.Sprintf("%q is not a task field", field))
+	}
+	if len(p.fields) == 0 && len(p.exclude) == 0 && !p.links {
+		return nil
+	}
+	return p
+}
+
+// The apply() method reshapes the tasks in an envelope. The envelope is
+// round-tripped through JSON so that the field names match the output.
+// A nil projection leaves the envelope alone
+func (p *projection) apply(env envelope) (envelope, error) {
+	if p == nil {
+		return env, nil
+	}
+	js, err := json.Marshal(env)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.UseNumber()
+	var projected map[string]interface{}
+	err = dec.Decode(&projected)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range projectedKeys {
+		switch value := projected[key].(type) {
+		case map[string]interface{}:
+			projected[key] = p.todo(value)
+		case []interface{}:
+			for i, item := range value {
+				if todo, ok := item.(map[string]interface{}); ok {
+					value[i] = p.todo(todo)
+				}
+			}
+		}
+	}
+	return envelope(projected), nil
+}
+
+// The todo() method reshapes a single task, along with the tasks nested
+// inside it
+func (p *projection) todo(todo map[string]interface{}) map[string]interface{} {
+	if next, ok := todo["next_todo"].(map[string]interface{}); ok {
+		todo["next_todo"] = p.todo(next)
+	}
+	if subtasks, ok := todo["subtasks"].([]interface{}); ok {
+		for i, item := range subtasks {
+			if subtask, ok := item.(map[string]interface{}); ok {
+				subtasks[i] = p.todo(subtask)
+			}
+		}
+	}
+	// The links are worked out before any fields are dropped
+	var links map[string]string
+	if p.links {
+		links = todoLinks(todo)
+	}
+	if len(p.fields) > 0 {
+		kept := make(map[string]interface{})
+		for _, field := range p.fields {
+			if value, ok := todo[field]; ok {
+				kept[field] = value
+			}
+		}
+		todo = kept
+	}
+	for _, field := range p.exclude {
+		delete(todo, field)
+	}
+	if links != nil {
+		todo["_links"] = links
+	}
+	return todo
+}
+
+// The todoLinks() function lists the endpoints related to a task
+func todoLinks(todo map[string]interface{}) map[string]string {
+	links := map[string]string{}
+	id, ok := todo["id"].(json.Number)
+	if !ok {
+		return links
+	}
+	links["self"] = fmt.Sprintf("/v1/todos/%s", id)
+	links["subtasks"] = fmt.Sprintf("/v1/todos/%s/subtasks", id)
+	links["history"] = fmt.Sprintf("/v1/todos/%s/history", id)
+	if listID, ok := todo["list_id"].(json.Number); ok {
+		links["list"] = fmt.Sprintf("/v1/lists/%s", listID)
+	}
+	if parentID, ok := todo["parent_id"].(json.Number); ok {
+		links["parent"] = fmt.Sprintf("/v1/todos/%s", parentID)
+	}
+	if todo["recurrence"] != nil {
+		links["occurrences"] = fmt.Sprintf("/v1/todos/%s/occurrences", id)
+	}
+	return links
+}
@@ -5,20 +5,54 @@ package main
 import (
 	"net/http"
 
+	"AWD_Quiz3.ryanarmstrong.net/internal/data"
 	"github.com/julienschmidt/httprouter"
 )
 
-func (app *application) routes() *httprouter.Router {
+func (app *application) routes() http.Handler {
 	// Create a new httprouter router instance
 	router := httprouter.New()
 	router.NotFound = http.HandlerFunc(app.notFoundResponse)
 	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
 	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
-	router.HandlerFunc(http.MethodGet, "/v1/todos", app.listTodosHandler)
-	router.HandlerFunc(http.MethodPost, "/v1/todos", app.createTodoHandler)
-	router.HandlerFunc(http.MethodGet, "/v1/todos/:id", app.showTodoHandler)
-	router.HandlerFunc(http.MethodPatch, "/v1/todos/:id", app.updateTodoHandler)
-	router.HandlerFunc(http.MethodDelete, "/v1/todos/:id", app.deleteTodoHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/todos", app.requirePermission(data.PermissionTodosRead, app.listTodosHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/todos", app.requirePermission(data.PermissionTodosWrite, app.createTodoHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/todos/:id", app.requirePermission(data.PermissionTodosRead, app.showTodoHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/todos/:id", app.requirePermission(data.PermissionTodosWrite, app.updateTodoHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/todos/:id", app.requirePermission(data.PermissionTodosWrite, app.deleteTodoHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/todos/:id/history", app.requirePermission(data.PermissionTodosRead, app.listTodoHistoryHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/todos/:id/occurrences", app.requirePermission(data.PermissionTodosRead, app.listOccurrencesHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/todos/:id/subtasks", app.requirePermission(data.PermissionTodosRead, app.listSubtasksHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/todos/:id/subtasks", app.requirePermission(data.PermissionTodosWrite, app.createTodoHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/lists", app.requirePermission(data.PermissionTodosRead, app.listListsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/lists", app.requirePermission(data.PermissionTodosWrite, app.createListHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/lists/:id", app.requirePermission(data.PermissionTodosRead, app.showListHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/lists/:id", app.requirePermission(data.PermissionTodosWrite, app.updateListHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/lists/:id", app.requirePermission(data.PermissionTodosWrite, app.deleteListHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/lists/:id/todos", app.requirePermission(data.PermissionTodosRead, app.listTodosHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/todos/:id/restore", app.requirePermission(data.PermissionTodosWrite, app.restoreTodoHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/trash", app.requirePermission(data.PermissionTodosRead, app.listTrashHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/trash/:id", app.requirePermission(data.PermissionTodosWrite, app.purgeTodoHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/tags", app.requirePermission(data.PermissionTodosRead, app.listTagsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/webhooks", app.requirePermission(data.PermissionTodosRead, app.listWebhooksHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/webhooks", app.requirePermission(data.PermissionTodosWrite, app.createWebhookHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/webhooks/:id", app.requirePermission(data.PermissionTodosRead, app.showWebhookHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/webhooks/:id", app.requirePermission(data.PermissionTodosWrite, app.updateWebhookHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/webhooks/:id", app.requirePermission(data.PermissionTodosWrite, app.deleteWebhookHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/webhooks/:id/deliveries", app.requirePermission(data.PermissionTodosRead, app.listWebhookDeliveriesHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/ws", app.requirePermission(data.PermissionTodosRead, app.wsHandler))
 
-	return router
+	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+
+	// httprouter cannot register /v1/todos/bulk, /v1/todos/events or
+	// /v1/todos/search beside the /v1/todos/:id routes, so they are matched
+	// before the request reaches the router
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/todos/bulk", app.allowMethod(http.MethodPost, app.requirePermission(data.PermissionTodosWrite, app.bulkTodosHandler)))
+	mux.HandleFunc("/v1/todos/events", app.allowMethod(http.MethodGet, app.requirePermission(data.PermissionTodosRead, app.todoEventsHandler)))
+	mux.HandleFunc("/v1/todos/search", app.allowMethod(http.MethodGet, app.requirePermission(data.PermissionTodosRead, app.searchTodosHandler)))
+	mux.Handle("/", router)
+
+	return app.requestID(app.authenticate(mux))
 }
@@ -0,0 +1,49 @@
+// Filename: cmd/api/search.go
+
+package main
+
+import (
+	"net/http"
+
+	"AWD_Quiz3.ryanarmstrong.net/internal/data"
+	"AWD_Quiz3.ryanarmstrong.net/internal/validator"
+)
+
+// The searchTodosHandler for the "GET /v1/todos/search" endpoint returns
+// the tasks that match a full-text search, best match first, with the
+// matching words highlighted. With fuzzy=true tasks are matched and ranked
+// by their similarity to the search instead
+func (app *application) searchTodosHandler(w http.ResponseWriter, r *http.Request) {
+	// Initialize a validator
+	v := validator.New()
+	qs := r.URL.Query()
+	search := data.TodoSearch{
+		Query:    app.readString(qs, "q", ""),
+		Language: app.readString(qs, "language", "simple"),
+		Prefix:   app.readBool(qs, "prefix", true, v),
+		// A fuzzy search tolerates typos by comparing trigrams
+		Fuzzy:         app.readBool(qs, "fuzzy", false, v),
+		MinSimilarity: app.readFloat(qs, "min_similarity", data.DefaultMinSimilarity, v),
+	}
+	// Results are always ordered by rank
+	filters := data.Filters{
+		Page:     app.readInt(qs, "page", 1, v),
+		PageSize: app.readInt(qs, "page_size", 20, v),
+		Sort:     []string{"-rank"},
+		SortList: []string{"-rank"},
+	}
+	data.ValidateTodoSearch(v, search)
+	if data.ValidateFilers(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	results, metadata, err := app.models.Todos.Search(app.contextGetUser(r).ID, search, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeListingJSON(w, r, envelope{"results": results, "metadata": metadata})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,21 @@
+// Filename: cmd/api/tag.go
+
+package main
+
+import (
+	"net/http"
+)
+
+// The listTagsHandler allows the client to see their tags and how many
+// tasks use each one
+func (app *application) listTagsHandler(w http.ResponseWriter, r *http.Request) {
+	tags, err := app.models.Tags.GetAllForUser(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeListingJSON(w, r, envelope{"tags": tags})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -6,46 +6,87 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"AWD_Quiz3.ryanarmstrong.net/internal/data"
+	"AWD_Quiz3.ryanarmstrong.net/internal/query"
 	"AWD_Quiz3.ryanarmstrong.net/internal/validator"
+	"github.com/julienschmidt/httprouter"
 )
 
-// createTodoHandler for the "Post /v1/todos" endpoint
+// createTodoInput holds the fields a client may set on a new task
+type createTodoInput struct {
+	Task       string     `json:"task"`
+	ListID     *int64     `json:"list_id"`
+	ParentID   *int64     `json:"parent_id"`
+	Tags       []string   `json:"tags"`
+	StartAt    *time.Time `json:"start_at"`
+	DueAt      *time.Time `json:"due_at"`
+	Recurrence string     `json:"recurrence"`
+	Priority   int        `json:"priority"`
+}
+
+// updateTodoInput holds the fields a client may change on a task. We use
+// pointers because pointers have a default value of nil. If a field
+// remains nil then we know the client did not update it
+type updateTodoInput struct {
+	Task       *string    `json:"task"`
+	Status     *string    `json:"status"`
+	ListID     *int64     `json:"list_id"`
+	ParentID   *int64     `json:"parent_id"`
+	Tags       []string   `json:"tags"`
+	StartAt    *time.Time `json:"start_at"`
+	DueAt      *time.Time `json:"due_at"`
+	Recurrence *string    `json:"recurrence"`
+	Priority   *int       `json:"priority"`
+}
+
+// createTodoHandler for the "Post /v1/todos" endpoint. When mounted under
+// "/v1/todos/:id/subtasks" the new task is a subtask of the task in the URL
 func (app *application) createTodoHandler(w http.ResponseWriter, r *http.Request) {
 	// Our target decode destination
-	var input struct {
-		Task string `json:"task"`
-	}
+	var input createTodoInput
 	// Initialize a new json.Decoder instance
 	err := app.readJSON(w, r, &input)
 	if err != nil {
 		app.badRequestResponse(w, r, err)
 		return
 	}
-
-	// Copy the values from the input struct to a new Todo struct
-	todo := &data.Todo{
-		Task: input.Task,
+	// Take the parent from the URL for nested routes
+	if httprouter.ParamsFromContext(r.Context()).ByName("id") != "" {
+		parentID, err := app.readIDParam(r)
+		if err != nil {
+			app.notFoundResponse(w, r)
+			return
+		}
+		input.ParentID = &parentID
 	}
 	// Initialize a new Validator instance
 	v := validator.New()
-
+	todo, err := app.newTodo(v, app.models.Todos, app.contextGetUser(r).ID, input)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
 	// Check the map to determine if there were any validation errors
-	if data.ValidateTodo(v, todo); !v.Valid() {
+	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
 	// Create a Task
-	err = app.models.Todos.Insert(todo)
+	err = app.modelsFor(r).Todos.Insert(todo)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+		return
 	}
 
 	// Create a Location header for the newly created resource/Forum
 	headers := make(http.Header)
 	headers.Set("Location", fmt.Sprintf("/v1/todos/%d", todo.ID))
+	headers.Set("ETag", todoETag(todo))
 	// Write the JSON response with 201 - Created status code with the body
 	// being the Todo data and the header being the headers map
 	err = app.writeJSON(w, http.StatusCreated, envelope{"todo": todo}, headers)
@@ -54,6 +95,43 @@ func (app *application) createTodoHandler(w http.ResponseWriter, r *http.Request
 	}
 }
 
+// The newTodo() method copies the client's input into a new task and
+// validates it. The todos model is used for the checks so that a bulk
+// request sees the tasks it created earlier in its transaction
+func (app *application) newTodo(v *validator.Validator, todos data.TodoModel, userID int64, input createTodoInput) (*data.Todo, error) {
+	// Copy the values from the input struct to a new Todo struct
+	todo := &data.Todo{
+		UserID:     userID,
+		ListID:     input.ListID,
+		ParentID:   input.ParentID,
+		Task:       input.Task,
+		Status:     data.StatusTodo,
+		Tags:       input.Tags,
+		StartAt:    input.StartAt,
+		DueAt:      input.DueAt,
+		Recurrence: input.Recurrence,
+		Priority:   input.Priority,
+	}
+	// A Task without tags is listed with an empty array
+	if todo.Tags == nil {
+		todo.Tags = []string{}
+	}
+	// The list must belong to the caller
+	if todo.ListID != nil {
+		err := app.validateListOwnership(v, *todo.ListID, todo.UserID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// The parent must belong to the caller
+	err := app.validateParent(v, todos, todo)
+	if err != nil {
+		return nil, err
+	}
+	data.ValidateTodo(v, todo)
+	return todo, nil
+}
+
 // showTodoHandler for the "Post /v1/todos/:id" endpoint
 func (app *application) showTodoHandler(w http.ResponseWriter, r *http.Request) {
 	id, err := app.readIDParam(r)
@@ -61,9 +139,22 @@ func (app *application) showTodoHandler(w http.ResponseWriter, r *http.Request)
 		app.notFoundResponse(w, r)
 		return
 	}
+	// The "as_of" query parameter asks for the task as it was in the past
+	v := validator.New()
+	asOf := app.readTime(r.URL.Query(), "as_of", v)
+	// The client may pick the fields it wants
+	p := app.readProjection(r.URL.Query(), v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	if asOf != nil {
+		app.showTodoAsOf(w, r, id, *asOf, p)
+		return
+	}
 
 	// Fetch the specific task
-	todo, err := app.models.Todos.Get(id)
+	todo, err := app.models.Todos.Get(id, app.contextGetUser(r).ID)
 	// Handle errors
 	if err != nil {
 		switch {
@@ -74,8 +165,22 @@ func (app *application) showTodoHandler(w http.ResponseWriter, r *http.Request)
 		}
 		return
 	}
+	// Embed the subtasks and how far along they are
+	todo.Subtasks, err = app.models.Todos.GetSubtasks(todo.ID, todo.UserID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	todo.SetProgress()
+	// The client may already hold this version of the task and its subtasks
+	etag := todoETag(todo)
+	if app.notModified(w, r, etag) {
+		return
+	}
+	headers := make(http.Header)
+	headers.Set("ETag", etag)
 	// Write the data returned by Get()
-	err = app.writeJSON(w, http.StatusOK, envelope{"todo": todo}, nil)
+	err = app.writeProjectedJSON(w, http.StatusOK, p, envelope{"todo": todo}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -90,7 +195,7 @@ func (app *application) updateTodoHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 	// Fetch the original record from the database
-	todo, err := app.models.Todos.Get(id)
+	todo, err := app.models.Todos.Get(id, app.contextGetUser(r).ID)
 	// Handle errors
 	if err != nil {
 		switch {
@@ -101,40 +206,34 @@ func (app *application) updateTodoHandler(w http.ResponseWriter, r *http.Request
 		}
 		return
 	}
-	// Create an input struct to hold data read in from the client
-	// We update input struct to use pointers because pointers have a
-	// default value of nil
-	// If a field remains nil then we know the client did not update it
-	var input struct {
-		Task     *string `json:"task"`
-		Complete *string `json:"complete"`
+	// Refuse to patch a newer version than the one the client has seen
+	if app.preconditionFailed(w, r, todo) {
+		return
 	}
+	// Create an input struct to hold data read in from the client
+	var input updateTodoInput
 	// Initialize a new json.Decoder instance
 	err = app.readJSON(w, r, &input)
 	if err != nil {
 		app.badRequestResponse(w, r, err)
 		return
 	}
-	// Check for updates
-	if input.Task != nil {
-		todo.Task = *input.Task
-	}
-	if input.Complete != nil {
-		todo.Complete = *input.Complete
-	}
-
 	// Perform validation on the updated Task. If validation fails, then
 	// we send a 422 - Unprocessable Entity response to the client
 	// Initialize a new Validator instance
 	v := validator.New()
-
+	err = app.applyTodoUpdate(v, app.models.Todos, todo, input)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
 	// Check the map to determine if there were any validation errors
-	if data.ValidateTodo(v, todo); !v.Valid() {
+	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 	// Pass the updated Task record to the Update() method
-	err = app.models.Todos.Update(todo)
+	err = app.modelsFor(r).Todos.Update(todo)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
@@ -144,13 +243,72 @@ func (app *application) updateTodoHandler(w http.ResponseWriter, r *http.Request
 		}
 		return
 	}
+	headers := make(http.Header)
+	headers.Set("ETag", todoETag(todo))
 	// Write the data returned by Update()
-	err = app.writeJSON(w, http.StatusOK, envelope{"todo": todo}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"todo": todo}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
+// The applyTodoUpdate() method copies the fields supplied by the client
+// onto a task and validates the result. The todos model is used for the
+// checks so that a bulk request sees the tasks it changed earlier
+func (app *application) applyTodoUpdate(v *validator.Validator, todos data.TodoModel, todo *data.Todo, input updateTodoInput) error {
+	// Check for updates
+	if input.Task != nil {
+		todo.Task = *input.Task
+	}
+	if input.Status != nil {
+		// Only allow moves that the status lifecycle permits
+		data.ValidateStatusTransition(v, todo.Status, *input.Status)
+		// A task cannot be done while it has open subtasks
+		if *input.Status == data.StatusDone && todo.Status != data.StatusDone {
+			open, err := todos.CountOpenSubtasks(todo.ID, todo.UserID)
+			if err != nil {
+				return err
+			}
+			v.Check(open == 0, "status", "cannot be done while the task has open subtasks")
+		}
+		todo.Status = *input.Status
+	}
+	if input.ListID != nil {
+		// The list must belong to the caller
+		err := app.validateListOwnership(v, *input.ListID, todo.UserID)
+		if err != nil {
+			return err
+		}
+		todo.ListID = input.ListID
+	}
+	if input.ParentID != nil {
+		todo.ParentID = input.ParentID
+		// The parent must belong to the caller and must not create a cycle
+		err := app.validateParent(v, todos, todo)
+		if err != nil {
+			return err
+		}
+	}
+	if input.Tags != nil {
+		todo.Tags = input.Tags
+	}
+	if input.Priority != nil {
+		todo.Priority = *input.Priority
+	}
+	if input.StartAt != nil {
+		todo.StartAt = input.StartAt
+	}
+	if input.DueAt != nil {
+		todo.DueAt = input.DueAt
+	}
+	// An empty recurrence turns the task back into a one-off
+	if input.Recurrence != nil {
+		todo.Recurrence = *input.Recurrence
+	}
+	data.ValidateTodo(v, todo)
+	return nil
+}
+
 func (app *application) deleteTodoHandler(w http.ResponseWriter, r *http.Request) {
 	// Get the id for the task that needs to be deleted
 	id, err := app.readIDParam(r)
@@ -158,64 +316,325 @@ func (app *application) deleteTodoHandler(w http.ResponseWriter, r *http.Request
 		app.notFoundResponse(w, r)
 		return
 	}
-	// Delete the Task from the database. Send a 404 Not Found status code to the
+	// The client may name the version it expects to delete, either with
+	// the "version" query parameter or with an If-Match header
+	var version *int32
+	v := validator.New()
+	if r.URL.Query().Get("version") != "" {
+		expected := app.readInt32(r.URL.Query(), "version", 0, v)
+		if v.Valid() {
+			v.Check(expected > 0, "version", "must be greater than zero")
+		}
+		version = &expected
+	}
+	ifMatch := strings.TrimSpace(r.Header.Get("If-Match"))
+	// The two ways of naming the version must agree
+	if v.Valid() && version != nil && ifMatch != "" {
+		v.Check(versionMatches(ifMatch, &data.Todo{ID: id, Version: *version}), "version", "must match the version in the If-Match header")
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	// An If-Match header is checked the same way as for an update, and the
+	// delete is then held to the version that was checked
+	if ifMatch != "" {
+		todo, err := app.models.Todos.Get(id, app.contextGetUser(r).ID)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+		if app.preconditionFailed(w, r, todo) {
+			return
+		}
+		if version == nil && ifMatch != "*" {
+			version = &todo.Version
+		}
+	}
+	// Move the Task to the trash. Send a 404 Not Found status code to the
 	// client if there is no matching record
-	err = app.models.Todos.Delete(id)
+	err = app.modelsFor(r).Todos.Delete(id, app.contextGetUser(r).ID, version)
 	// Handle errors
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
 			app.notFoundResponse(w, r)
+		// The Task changed after the If-Match header was checked
+		case errors.Is(err, data.ErrEditConflict) && ifMatch != "" && r.URL.Query().Get("version") == "":
+			app.preconditionFailedResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
 	// Return 200 Status OK to the client with a successful message
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "task successfully deleted"}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "task moved to the trash"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
 // The listTodosHandler allows the client to see a listing of tasks
-// based on a set of criteria
+// based on a set of criteria. When mounted under "/v1/lists/:id/todos"
+// only the tasks in that list are shown
 func (app *application) listTodosHandler(w http.ResponseWriter, r *http.Request) {
 	// Create an input struct to hold our query parameters
 	var input struct {
-		Task     string
-		Complete string
+		data.TodoFilters
 		data.Filters
 	}
 	// Initialize a validator
 	v := validator.New()
+	// Limit nested listings to the list in the URL
+	if httprouter.ParamsFromContext(r.Context()).ByName("id") != "" {
+		listID, err := app.readIDParam(r)
+		if err != nil {
+			app.notFoundResponse(w, r)
+			return
+		}
+		_, err = app.models.Lists.Get(listID, app.contextGetUser(r).ID)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+		input.ListID = &listID
+	}
 	// Get the URL values map
 	qs := r.URL.Query()
 	// Use the helper methods to extract the values
 	input.Task = app.readString(qs, "task", "")
-	input.Complete = app.readString(qs, "complete", "")
+	input.Status = app.readCSV(qs, "status", []string{})
+	input.DueBefore = app.readTime(qs, "due_before", v)
+	input.DueAfter = app.readTime(qs, "due_after", v)
+	input.Overdue = app.readBool(qs, "overdue", false, v)
+	input.Tags = app.readCSV(qs, "tags", []string{})
+	input.TagsAll = app.readCSV(qs, "tags_all", []string{})
+	data.ValidateTags(v, "tags", input.Tags)
+	data.ValidateTags(v, "tags_all", input.TagsAll)
+	for _, value := range app.readCSV(qs, "priority", []string{}) {
+		priority, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || priority < data.PriorityNone || priority > data.PriorityUrgent {
+			v.AddError("priority", "must only contain integers between 0 and 4")
+			continue
+		}
+		input.Priority = append(input.Priority, priority)
+	}
+	for _, status := range input.Status {
+		v.Check(validator.In(status, data.StatusList...), "status", "must only contain todo, in_progress, blocked, done or cancelled")
+	}
+	// The filter expression can combine conditions on any field
+	if expression := app.readString(qs, "filter", ""); expression != "" {
+		v.Check(len(expression) <= 1000, "filter", "must not be more than 1000 bytes long")
+		filter, err := query.Parse(expression)
+		if err != nil {
+			v.AddError("filter", err.Error())
+		} else {
+			data.ValidateTodoFilter(v, filter)
+			input.Filter = filter
+		}
+	}
+	// A fuzzy listing matches the task by trigram similarity, so typos
+	// still find it
+	input.Fuzzy = app.readBool(qs, "fuzzy", false, v)
+	input.MinSimilarity = app.readFloat(qs, "min_similarity", data.DefaultMinSimilarity, v)
+	if input.Fuzzy {
+		v.Check(input.Task != "", "task", "must be provided for a fuzzy listing")
+		data.ValidateMinSimilarity(v, input.MinSimilarity)
+	}
+	// The complete filter is shorthand for done / not done
+	switch strings.ToLower(app.readString(qs, "complete", "")) {
+	case "":
+	case "true", "yes":
+		input.Status = []string{data.StatusDone}
+	case "false", "no":
+		input.Status = []string{data.StatusTodo, data.StatusInProgress, data.StatusBlocked, data.StatusCancelled}
+	default:
+		v.AddError("complete", "must be true or false")
+	}
 	// Get the page information
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	// A cursor from a previous response continues the listing after it
+	input.Filters.Cursor = app.readString(qs, "cursor", "")
+	// The client may pick the fields it wants
+	p := app.readProjection(qs, v)
 	// Get the sort information
-	input.Filters.Sort = app.readString(qs, "sort", "id")
+	// The default ordering puts the most urgent tasks first
+	input.Filters.Sort = app.readCSV(qs, "sort", []string{"smart"})
 	// Specify the allowed sort values
-	input.Filters.SortList = []string{"id", "task", "complete", "-id", "-task", "-complete"}
+	input.Filters.SortList = []string{"id", "created_at", "task", "status", "complete", "due_at", "priority", "smart", "-id", "-created_at", "-task", "-status", "-complete", "-due_at", "-priority"}
 	// Check for validation errors
 	if data.ValidateFilers(v, input.Filters); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 	// Get a listing of all tasks
-	todos, metadata, err := app.models.Todos.GetAll(input.Task, input.Complete, input.Filters)
+	todos, metadata, err := app.models.Todos.GetAll(app.contextGetUser(r).ID, input.TodoFilters, input.Filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 	// Send a JSON response containing all the forums
-	err = app.writeJSON(w, http.StatusOK, envelope{"todos": todos, "metadata": metadata}, nil)
+	err = app.writeProjectedListingJSON(w, r, p, envelope{"todos": todos, "metadata": metadata})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// The listSubtasksHandler for the "GET /v1/todos/:id/subtasks" endpoint
+// returns the subtasks of a task and how far along they are
+func (app *application) listSubtasksHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	// The client may pick the fields it wants
+	v := validator.New()
+	p := app.readProjection(r.URL.Query(), v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	// Fetch the parent task
+	todo, err := app.models.Todos.Get(id, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	todo.Subtasks, err = app.models.Todos.GetSubtasks(todo.ID, todo.UserID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	todo.SetProgress()
+	err = app.writeProjectedJSON(w, http.StatusOK, p, envelope{"subtasks": todo.Subtasks, "progress": todo.Progress}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The validateParent() method checks that the parent of a task refers to
+// one of the caller's tasks and that it would not create a cycle
+func (app *application) validateParent(v *validator.Validator, todos data.TodoModel, todo *data.Todo) error {
+	if todo.ParentID == nil {
+		return nil
+	}
+	_, err := todos.Get(*todo.ParentID, todo.UserID)
+	if errors.Is(err, data.ErrRecordNotFound) {
+		v.AddError("parent_id", "must refer to an existing task")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// A task that has not been created yet cannot be part of a cycle
+	if todo.ID == 0 {
+		return nil
+	}
+	cycle, err := todos.CreatesCycle(todo.ID, *todo.ParentID)
+	if err != nil {
+		return err
+	}
+	v.Check(!cycle, "parent_id", "must not create a cycle")
+	return nil
+}
+
+// The listOccurrencesHandler for the "GET /v1/todos/:id/occurrences" endpoint
+// previews the upcoming dates of a recurring task
+func (app *application) listOccurrencesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
 		return
 	}
+	// Initialize a validator
+	v := validator.New()
+	limit := app.readInt(r.URL.Query(), "count", 5, v)
+	v.Check(limit > 0, "count", "must be greater than zero")
+	v.Check(limit <= 50, "count", "must be a maximum of 50")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	// Fetch the recurring task
+	todo, err := app.models.Todos.Get(id, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if todo.Recurrence == "" {
+		app.badRequestResponse(w, r, errors.New("the task does not recur"))
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"recurrence": todo.Recurrence, "occurrences": todo.Occurrences(limit)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The showTodoAsOf() method writes a task as it was recorded in its history
+// at a moment in the past
+func (app *application) showTodoAsOf(w http.ResponseWriter, r *http.Request, id int64, asOf time.Time, p *projection) {
+	todo, err := app.models.TodoEvents.GetAsOf(id, app.contextGetUser(r).ID, asOf)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeProjectedJSON(w, http.StatusOK, p, envelope{"todo": todo, "as_of": asOf}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The listTodoHistoryHandler for the "GET /v1/todos/:id/history" endpoint
+// returns every recorded change to a task, including trashed and purged ones
+func (app *application) listTodoHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	events, err := app.models.TodoEvents.GetAllForTodo(id, app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	// A task with no history does not belong to the caller
+	if len(events) == 0 {
+		app.notFoundResponse(w, r)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"history": events}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
 }
@@ -0,0 +1,55 @@
+// Filename: cmd/api/todo_test.go
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// A delete whose version cannot be used is refused before the task is
+// looked up
+func TestDeleteTodoHandlerRejectsVersions(t *testing.T) {
+	app := &application{}
+	tests := []struct {
+		name    string
+		query   string
+		ifMatch string
+		message string
+	}{
+		{"too large", "?version=4294967297", "", "must be between -2147483648 and 2147483647"},
+		{"not a number", "?version=x", "", "must be an integer value"},
+		{"zero", "?version=0", "", "must be greater than zero"},
+		{"disagrees with If-Match", "?version=2", `"7-3"`, "must match the version in the If-Match header"},
+		{"missing from an If-Match list", "?version=2", `"7-3", "7-4-0123456789abcdef"`, "must match the version in the If-Match header"},
+		{"If-Match for another task", "?version=3", `"8-3"`, "must match the version in the If-Match header"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodDelete, "/v1/todos/7"+tt.query, nil)
+		if tt.ifMatch != "" {
+			r.Header.Set("If-Match", tt.ifMatch)
+		}
+		r = r.WithContext(context.WithValue(r.Context(), httprouter.ParamsKey, httprouter.Params{{Key: "id", Value: "7"}}))
+		rr := httptest.NewRecorder()
+		app.deleteTodoHandler(rr, r)
+		if rr.Code != http.StatusUnprocessableEntity {
+			t.Errorf("%s: got status %d; want %d", tt.name, rr.Code, http.StatusUnprocessableEntity)
+			continue
+		}
+		var body struct {
+			Error map[string]string `json:"error"`
+		}
+		err := json.NewDecoder(rr.Body).Decode(&body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body.Error["version"] != tt.message {
+			t.Errorf("%s: got error %q; want %q", tt.name, body.Error["version"], tt.message)
+		}
+	}
+}
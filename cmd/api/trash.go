@@ -0,0 +1,127 @@
+// Filename: cmd/api/trash.go
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"AWD_Quiz3.ryanarmstrong.net/internal/data"
+	"AWD_Quiz3.ryanarmstrong.net/internal/validator"
+)
+
+// The listTrashHandler for the "GET /v1/trash" endpoint allows the client
+// to see the tasks they have deleted
+func (app *application) listTrashHandler(w http.ResponseWriter, r *http.Request) {
+	// Initialize a validator
+	v := validator.New()
+	// Get the URL values map
+	qs := r.URL.Query()
+	filters := data.Filters{
+		Page:     app.readInt(qs, "page", 1, v),
+		PageSize: app.readInt(qs, "page_size", 20, v),
+		Sort:     app.readCSV(qs, "sort", []string{"-deleted_at"}),
+		SortList: []string{"id", "task", "deleted_at", "-id", "-task", "-deleted_at"},
+		Cursor:   app.readString(qs, "cursor", ""),
+	}
+	if data.ValidateFilers(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	todos, metadata, err := app.models.Todos.GetAll(app.contextGetUser(r).ID, data.TodoFilters{Trashed: true}, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeListingJSON(w, r, envelope{"todos": todos, "metadata": metadata})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The restoreTodoHandler for the "POST /v1/todos/:id/restore" endpoint
+// takes a task back out of the trash
+func (app *application) restoreTodoHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	user := app.contextGetUser(r)
+	err = app.modelsFor(r).Todos.Restore(id, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	// Fetch the restored task
+	todo, err := app.models.Todos.Get(id, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"todo": todo}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The purgeTodoHandler for the "DELETE /v1/trash/:id?permanent=true"
+// endpoint removes a task from the trash for good
+func (app *application) purgeTodoHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	// The client has to ask for a permanent delete explicitly
+	v := validator.New()
+	permanent := app.readBool(r.URL.Query(), "permanent", false, v)
+	v.Check(permanent, "permanent", "must be true to purge a task")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.modelsFor(r).Todos.Purge(id, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "task permanently deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The purgeExpiredTrash() method runs in the background and permanently
+// removes tasks that have been in the trash longer than the retention period,
+// followed by the deleted lists that are left empty
+func (app *application) purgeExpiredTrash() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		count, err := app.models.Todos.PurgeExpired(app.config.trash.retention)
+		if err != nil {
+			app.logger.Println(err)
+		} else if count > 0 {
+			app.logger.Printf("purged %d tasks from the trash", count)
+		}
+		count, err = app.models.Lists.PurgeExpired(app.config.trash.retention)
+		if err != nil {
+			app.logger.Println(err)
+		} else if count > 0 {
+			app.logger.Printf("purged %d deleted lists", count)
+		}
+		<-ticker.C
+	}
+}
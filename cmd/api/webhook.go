@@ -0,0 +1,254 @@
+// Filename: cmd/api/webhook.go
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"AWD_Quiz3.ryanarmstrong.net/internal/data"
+	"AWD_Quiz3.ryanarmstrong.net/internal/validator"
+)
+
+// createWebhookHandler for the "POST /v1/webhooks" endpoint. The response
+// is the only time the signing secret is shown
+func (app *application) createWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	// Our target decode destination
+	var input struct {
+		URL        string   `json:"url"`
+		EventTypes []string `json:"event_types"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	// Leaving out the event types subscribes to every kind of event
+	if input.EventTypes == nil {
+		input.EventTypes = []string{}
+	}
+	webhook := &data.Webhook{
+		UserID:     app.contextGetUser(r).ID,
+		URL:        input.URL,
+		EventTypes: input.EventTypes,
+	}
+	// Initialize a new Validator instance
+	v := validator.New()
+	if data.ValidateWebhook(v, webhook); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	if app.validateWebhookHost(r.Context(), v, webhook); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	// Create the Webhook
+	err = app.models.Webhooks.Insert(webhook)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	// Create a Location header for the newly created resource
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/webhooks/%d", webhook.ID))
+	err = app.writeJSON(w, http.StatusCreated, envelope{"webhook": webhook}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showWebhookHandler for the "GET /v1/webhooks/:id" endpoint
+func (app *application) showWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	// Fetch the specific webhook
+	webhook, err := app.models.Webhooks.Get(id, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"webhook": webhook}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateWebhookHandler for the "PATCH /v1/webhooks/:id" endpoint. Setting
+// active to true turns a webhook that was disabled after failing back on
+func (app *application) updateWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	// Fetch the original record from the database
+	webhook, err := app.models.Webhooks.Get(id, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	// A nil field was not supplied by the client
+	var input struct {
+		URL        *string   `json:"url"`
+		EventTypes *[]string `json:"event_types"`
+		Active     *bool     `json:"active"`
+	}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if input.URL != nil {
+		webhook.URL = *input.URL
+	}
+	if input.EventTypes != nil {
+		webhook.EventTypes = *input.EventTypes
+		if webhook.EventTypes == nil {
+			webhook.EventTypes = []string{}
+		}
+	}
+	if input.Active != nil {
+		webhook.Active = *input.Active
+	}
+	// Initialize a new Validator instance
+	v := validator.New()
+	if data.ValidateWebhook(v, webhook); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	if app.validateWebhookHost(r.Context(), v, webhook); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.Webhooks.Update(webhook)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	webhook.Active = webhook.DisabledAt == nil
+	err = app.writeJSON(w, http.StatusOK, envelope{"webhook": webhook}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteWebhookHandler for the "DELETE /v1/webhooks/:id" endpoint
+func (app *application) deleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	err = app.models.Webhooks.Delete(id, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "webhook successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The listWebhooksHandler allows the client to see all of their webhooks
+func (app *application) listWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	webhooks, err := app.models.Webhooks.GetAll(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeListingJSON(w, r, envelope{"webhooks": webhooks})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The listWebhookDeliveriesHandler for the "GET /v1/webhooks/:id/deliveries"
+// endpoint shows what happened to the deliveries of a webhook, newest first
+func (app *application) listWebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	userID := app.contextGetUser(r).ID
+	// Make sure the webhook exists so that an empty listing means something
+	_, err = app.models.Webhooks.Get(id, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	// Initialize a validator
+	v := validator.New()
+	qs := r.URL.Query()
+	// Deliveries are always listed newest first
+	filters := data.Filters{
+		Page:     app.readInt(qs, "page", 1, v),
+		PageSize: app.readInt(qs, "page_size", 20, v),
+		Sort:     []string{"-id"},
+		SortList: []string{"-id"},
+	}
+	if data.ValidateFilers(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	deliveries, metadata, err := app.models.Webhooks.GetDeliveries(id, userID, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeListingJSON(w, r, envelope{"deliveries": deliveries, "metadata": metadata})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The validateWebhookHost() method checks that the URL of a validated
+// Webhook only resolves to public addresses. Deliveries are sent from
+// inside our network, so the receiver must not be one of our own
+// addresses. The worker checks again when it connects in case the name
+// has since been pointed elsewhere
+func (app *application) validateWebhookHost(ctx context.Context, v *validator.Validator, webhook *data.Webhook) {
+	u, err := url.Parse(webhook.URL)
+	if err != nil {
+		v.AddError("url", "must be an absolute http or https URL")
+		return
+	}
+	// Create a context
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	v.Check(publicHost(ctx, u.Hostname()), "url", "must resolve to public IP addresses only")
+}
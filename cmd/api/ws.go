@@ -0,0 +1,324 @@
+// Filename: cmd/api/ws.go
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"AWD_Quiz3.ryanarmstrong.net/internal/data"
+	"AWD_Quiz3.ryanarmstrong.net/internal/query"
+	"AWD_Quiz3.ryanarmstrong.net/internal/validator"
+	"github.com/gorilla/websocket"
+)
+
+// Limits and timings for WebSocket connections
+const (
+	wsWriteWait        = 10 * time.Second    // time allowed to write a frame
+	wsPongWait         = 60 * time.Second    // time allowed between pongs
+	wsPingPeriod       = wsPongWait * 9 / 10 // must be less than wsPongWait
+	wsMaxMessageSize   = 64 * 1024           // largest frame a client may send
+	wsSendBuffer       = 64                  // frames queued before a client is too slow
+	wsMaxSubscriptions = 20
+)
+
+// The scopes a subscription can cover
+const (
+	wsScopeAll    = "all"    // every task
+	wsScopeTodo   = "todo"   // a single task
+	wsScopeFilter = "filter" // tasks matching a filter expression
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// A wsMessage is a frame sent by the client. Mutations use the same fields
+// as a bulk operation
+type wsMessage struct {
+	Type         string          `json:"type"` // subscribe, unsubscribe, create, update or delete
+	Ref          string          `json:"ref"`  // echoed back in the reply
+	Scope        string          `json:"scope"`
+	TodoID       int64           `json:"todo_id"`
+	Filter       string          `json:"filter"`
+	Subscription string          `json:"subscription"`
+	ID           int64           `json:"id"`
+	Version      *int32          `json:"version"`
+	Todo         json.RawMessage `json:"todo"`
+}
+
+// A wsSubscription is one set of tasks a client is watching
+type wsSubscription struct {
+	scope  string
+	todoID int64
+	filter query.Node
+}
+
+// A wsClient is a single WebSocket connection. The readPump() and
+// writePump() goroutines are the only ones that touch the connection
+type wsClient struct {
+	hub      *hub
+	conn     *websocket.Conn
+	user     *data.User
+	canWrite bool
+	models   data.Models
+	// Frames waiting to be written
+	send chan envelope
+	// Closed when the connection should shut down
+	done      chan struct{}
+	closeOnce sync.Once
+	closeCode int
+	closeText string
+	// The subscriptions are used by the hub as well as readPump()
+	mu            sync.Mutex
+	subscriptions map[string]wsSubscription
+	nextID        int
+}
+
+// The wsHandler for the "GET /v1/ws" endpoint upgrades the connection to a
+// WebSocket. Clients subscribe to changes to their tasks and can create,
+// update and delete tasks over the same connection
+func (app *application) wsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	// Mutations need the write permission as well
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	// The upgrader sends its own error response
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	c := &wsClient{
+		hub:           app.hub,
+		conn:          conn,
+		user:          user,
+		canWrite:      permissions.Include(data.PermissionTodosWrite),
+		models:        app.modelsFor(r),
+		send:          make(chan envelope, wsSendBuffer),
+		done:          make(chan struct{}),
+		subscriptions: make(map[string]wsSubscription),
+	}
+	app.hub.register(c)
+	go c.writePump()
+	app.readPump(c)
+}
+
+// The close() method asks writePump() to send a close frame and hang up.
+// Only the first call has any effect
+func (c *wsClient) close(code int, text string) {
+	c.closeOnce.Do(func() {
+		c.closeCode = code
+		c.closeText = text
+		close(c.done)
+	})
+}
+
+// The reply() method queues a frame for the client, waiting while the
+// queue is full. It returns false once the connection is closing
+func (c *wsClient) reply(frame envelope) bool {
+	select {
+	case c.send <- frame:
+		return true
+	case <-c.done:
+		return false
+	}
+}
+
+// The deliver() method queues a change for every subscription that covers
+// it without waiting. It returns false if the queue is full
+func (c *wsClient) deliver(change *wsChange) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id, subscription := range c.subscriptions {
+		frame := envelope{"type": "change", "subscription": id, "change": change.TodoChange}
+		switch subscription.scope {
+		case wsScopeTodo:
+			if subscription.todoID != change.TodoID {
+				continue
+			}
+		case wsScopeFilter:
+			// Changes that move a task out of the filter are sent too, so
+			// the client knows to drop it
+			matches := change.Type != data.EventPurged && change.Todo.DeletedAt == nil && data.MatchTodoFilter(subscription.filter, change.Todo)
+			matched := change.old != nil && change.old.DeletedAt == nil && data.MatchTodoFilter(subscription.filter, change.old)
+			if !matches && !matched {
+				continue
+			}
+			frame["matches"] = matches
+		}
+		select {
+		case c.send <- frame:
+		case <-c.done:
+			return true
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// The writePump() method writes queued frames to the connection and pings
+// the client so that dead connections are noticed
+func (c *wsClient) writePump() {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+	for {
+		select {
+		case frame := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			err := c.conn.WriteJSON(frame)
+			if err != nil {
+				c.close(websocket.CloseAbnormalClosure, "")
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			err := c.conn.WriteMessage(websocket.PingMessage, nil)
+			if err != nil {
+				c.close(websocket.CloseAbnormalClosure, "")
+				return
+			}
+		case <-c.done:
+			message := websocket.FormatCloseMessage(c.closeCode, c.closeText)
+			c.conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(wsWriteWait))
+			return
+		}
+	}
+}
+
+// The readPump() method reads frames from the client until the connection
+// goes away, handling each one in turn
+func (app *application) readPump(c *wsClient) {
+	defer func() {
+		c.hub.unregister(c)
+		c.close(websocket.CloseNormalClosure, "")
+	}()
+	c.conn.SetReadLimit(wsMaxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+	for {
+		_, message, err := c.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway, websocket.CloseNoStatusReceived) {
+				app.logger.Println(err)
+			}
+			return
+		}
+		var msg wsMessage
+		err = json.Unmarshal(message, &msg)
+		if err != nil {
+			c.reply(envelope{"type": "error", "status": http.StatusBadRequest, "error": "body contains badly-formed JSON"})
+			continue
+		}
+		frame, err := app.handleWSMessage(c, msg)
+		if err != nil {
+			app.logger.Println(err)
+			frame = envelope{"type": "error", "status": http.StatusInternalServerError, "error": "the server encountered a problem and could not process your request"}
+		}
+		if msg.Ref != "" {
+			frame["ref"] = msg.Ref
+		}
+		if !c.reply(frame) {
+			return
+		}
+	}
+}
+
+// The handleWSMessage() method carries out a frame sent by the client and
+// returns the reply. Only unexpected failures are returned as errors
+func (app *application) handleWSMessage(c *wsClient, msg wsMessage) (envelope, error) {
+	switch msg.Type {
+	case "subscribe":
+		return c.subscribe(msg), nil
+	case "unsubscribe":
+		c.mu.Lock()
+		_, ok := c.subscriptions[msg.Subscription]
+		delete(c.subscriptions, msg.Subscription)
+		c.mu.Unlock()
+		if !ok {
+			return envelope{"type": "error", "status": http.StatusNotFound, "error": "the requested subscription could not be found"}, nil
+		}
+		return envelope{"type": "unsubscribed", "subscription": msg.Subscription}, nil
+	case "create", "update", "delete":
+		return app.mutateOverWS(c, msg)
+	}
+	v := validator.New()
+	v.AddError("type", "must be subscribe, unsubscribe, create, update or delete")
+	return envelope{"type": "error", "status": http.StatusUnprocessableEntity, "error": v.Errors}, nil
+}
+
+// The subscribe() method starts watching the tasks a frame asks for
+func (c *wsClient) subscribe(msg wsMessage) envelope {
+	subscription := wsSubscription{scope: msg.Scope, todoID: msg.TodoID}
+	v := validator.New()
+	v.Check(validator.In(msg.Scope, wsScopeAll, wsScopeTodo, wsScopeFilter), "scope", "must be all, todo or filter")
+	switch msg.Scope {
+	case wsScopeTodo:
+		v.Check(msg.TodoID > 0, "todo_id", "must be a positive integer")
+	case wsScopeFilter:
+		v.Check(msg.Filter != "", "filter", "must be provided")
+		v.Check(len(msg.Filter) <= 1000, "filter", "must not be more than 1000 bytes long")
+		filter, err := query.Parse(msg.Filter)
+		if err != nil {
+			v.AddError("filter", err.Error())
+			break
+		}
+		data.ValidateTodoFilter(v, filter)
+		subscription.filter = filter
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v.Check(len(c.subscriptions) < wsMaxSubscriptions, "subscription", fmt.Sprintf("must not have more than %d open at once", wsMaxSubscriptions))
+	if !v.Valid() {
+		return envelope{"type": "error", "status": http.StatusUnprocessableEntity, "error": v.Errors}
+	}
+	c.nextID++
+	id := "s" + strconv.Itoa(c.nextID)
+	c.subscriptions[id] = subscription
+	return envelope{"type": "subscribed", "subscription": id}
+}
+
+// The mutateOverWS() method runs a create, update or delete with the same
+// checks as the bulk endpoint. Updates and deletes must say which version
+// of the task they expect, and a different version is reported in a
+// conflict frame along with the task as it is now
+func (app *application) mutateOverWS(c *wsClient, msg wsMessage) (envelope, error) {
+	if !c.canWrite {
+		return envelope{"type": "error", "status": http.StatusForbidden, "error": "your user account doesn't have the necessary permissions to access this resource"}, nil
+	}
+	if msg.Type != "create" && msg.Version == nil {
+		v := validator.New()
+		v.AddError("version", "must be provided")
+		return envelope{"type": "error", "status": http.StatusUnprocessableEntity, "error": v.Errors}, nil
+	}
+	operation := bulkOperation{Op: msg.Type, ID: msg.ID, Version: msg.Version, Todo: msg.Todo}
+	result, err := app.runBulkOperation(c.models.Todos, c.user.ID, operation)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case result.Status == http.StatusConflict:
+		current, err := c.models.Todos.Get(msg.ID, c.user.ID)
+		if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+			return nil, err
+		}
+		return envelope{"type": "conflict", "op": msg.Type, "id": msg.ID, "version": *msg.Version, "current": current, "error": result.Error}, nil
+	case result.failed():
+		return envelope{"type": "error", "op": msg.Type, "id": msg.ID, "status": result.Status, "error": result.Error}, nil
+	}
+	return envelope{"type": "result", "op": msg.Type, "id": result.ID, "status": result.Status, "todo": result.Todo}, nil
+}
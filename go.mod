@@ -1,7 +1,11 @@
 module AWD_Quiz3.ryanarmstrong.net
 
-go 1.18
+go 1.20
 
 require github.com/julienschmidt/httprouter v1.3.0
 
 require github.com/lib/pq v1.10.2
+
+require golang.org/x/crypto v0.0.0-20220525230936-793ad666bf5e
+
+require github.com/gorilla/websocket v1.5.0
@@ -0,0 +1,439 @@
+// Filename: internal/data/event.go
+
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// The kinds of change recorded in the todo_events table
+const (
+	EventCreated  = "created"
+	EventUpdated  = "updated"
+	EventDeleted  = "deleted"
+	EventRestored = "restored"
+	EventPurged   = "purged"
+)
+
+// TodoEventTypes lists every kind of change, in the order they are usually
+// listed to clients
+var TodoEventTypes = []string{EventCreated, EventUpdated, EventDeleted, EventRestored, EventPurged}
+
+// A TodoEvent records a single change to a Task. The old and new values
+// are full snapshots of the Task, including its tags
+type TodoEvent struct {
+	ID        int64           `json:"id"`
+	CreatedAt time.Time       `json:"created_at"`
+	TodoID    int64           `json:"todo_id"`
+	UserID    int64           `json:"-"` // the owner of the Task
+	Type      string          `json:"type"`
+	OldValues json.RawMessage `json:"old_values,omitempty"`
+	NewValues json.RawMessage `json:"new_values,omitempty"`
+	Version   int32           `json:"version"`
+	ActorID   *int64          `json:"actor_id,omitempty"` // nil for system changes
+	RequestID string          `json:"request_id,omitempty"`
+	Position  int64           `json:"-"` // the order the event was committed in
+}
+
+// Define a TodoEventModel which wraps a sql.DB connection pool
+type TodoEventModel struct {
+	DB   *sql.DB
+	Feed *TodoFeed // wakes readers of GetAllAfter() when events are committed
+}
+
+// A TodoChange is the form an event takes when it is sent outside of the
+// API: to event streams, WebSockets, webhooks and the outbox
+type TodoChange struct {
+	EventID   int64     `json:"event_id"`
+	Type      string    `json:"type"`
+	TodoID    int64     `json:"todo_id"`
+	Version   int32     `json:"version"`
+	Todo      *Todo     `json:"todo"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// The Change() method turns an event into a TodoChange
+func (event *TodoEvent) Change() (*TodoChange, error) {
+	todo, err := event.Todo()
+	if err != nil {
+		return nil, err
+	}
+	change := &TodoChange{
+		EventID:   event.ID,
+		Type:      event.Type,
+		TodoID:    event.TodoID,
+		Version:   event.Version,
+		Todo:      todo,
+		CreatedAt: event.CreatedAt,
+	}
+	return change, nil
+}
+
+// The Todo() method returns the Task an event left behind. Deletes and
+// purges that have no new snapshot return the Task as it was before
+func (event *TodoEvent) Todo() (*Todo, error) {
+	if event.NewValues == nil {
+		return event.OldTodo()
+	}
+	return event.decode(event.NewValues)
+}
+
+// The OldTodo() method returns the Task as it was before the event, or nil
+// for a Task that the event created
+func (event *TodoEvent) OldTodo() (*Todo, error) {
+	if event.OldValues == nil {
+		return nil, nil
+	}
+	return event.decode(event.OldValues)
+}
+
+// The decode() method reads a Task back out of a snapshot
+func (event *TodoEvent) decode(snapshot json.RawMessage) (*Todo, error) {
+	todo, err := decodeTodoSnapshot(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	todo.UserID = event.UserID
+	return todo, nil
+}
+
+// The decodeTodoSnapshot() function reads a Task out of a snapshot taken
+// by snapshotTodos(). The snapshot holds every column, so the fields that
+// are kept out of the JSON output are read from it separately
+func decodeTodoSnapshot(snapshot []byte) (*Todo, error) {
+	var todo Todo
+	err := json.Unmarshal(snapshot, &todo)
+	if err != nil {
+		return nil, err
+	}
+	var columns struct {
+		CreatedAt  time.Time `json:"created_at"`
+		Occurrence int32     `json:"occurrence"`
+	}
+	err = json.Unmarshal(snapshot, &columns)
+	if err != nil {
+		return nil, err
+	}
+	todo.CreatedAt = columns.CreatedAt
+	todo.Occurrence = columns.Occurrence
+	return &todo, nil
+}
+
+// LatestPosition() returns the position of the newest event, or zero if
+// there are none. A reader that starts from here only sees changes
+// committed after it started
+func (m TodoEventModel) LatestPosition() (int64, error) {
+	query := `
+		SELECT COALESCE(MAX(position), 0)
+		FROM todo_events
+	`
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	var position int64
+	err := m.DB.QueryRowContext(ctx, query).Scan(&position)
+	if err != nil {
+		return 0, err
+	}
+	return position, nil
+}
+
+// GetAllAfter() returns up to limit of the events for a User's Tasks that
+// were committed after the event at position afterPosition, oldest first.
+// Positions are taken in the order transactions commit, so unlike ids they
+// can be used to resume reading without missing an event that committed
+// after a newer one
+func (m TodoEventModel) GetAllAfter(userID int64, afterPosition int64, limit int) ([]*TodoEvent, error) {
+	query := `
+		SELECT id, created_at, todo_id, user_id, event_type, old_values, new_values, version, actor_id, request_id, position
+		FROM todo_events
+		WHERE user_id = $1
+		AND position > $2
+		ORDER BY position ASC
+		LIMIT $3
+	`
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, userID, afterPosition, limit)
+	if err != nil {
+		return nil, err
+	}
+	// Close the resultset
+	defer rows.Close()
+	return scanTodoEvents(rows)
+}
+
+// GetAllForTodo() returns the history of a Task belonging to a User,
+// oldest change first
+func (m TodoEventModel) GetAllForTodo(todoID int64, userID int64) ([]*TodoEvent, error) {
+	query := `
+		SELECT id, created_at, todo_id, user_id, event_type, old_values, new_values, version, actor_id, request_id, position
+		FROM todo_events
+		WHERE todo_id = $1
+		AND user_id = $2
+		ORDER BY id ASC
+	`
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, todoID, userID)
+	if err != nil {
+		return nil, err
+	}
+	// Close the resultset
+	defer rows.Close()
+	return scanTodoEvents(rows)
+}
+
+// GetAllSince() returns up to limit of the events for every User's Tasks
+// that were committed after the event at position afterPosition, oldest
+// first
+func (m TodoEventModel) GetAllSince(afterPosition int64, limit int) ([]*TodoEvent, error) {
+	query := `
+		SELECT id, created_at, todo_id, user_id, event_type, old_values, new_values, version, actor_id, request_id, position
+		FROM todo_events
+		WHERE position > $1
+		ORDER BY position ASC
+		LIMIT $2
+	`
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, afterPosition, limit)
+	if err != nil {
+		return nil, err
+	}
+	// Close the resultset
+	defer rows.Close()
+	return scanTodoEvents(rows)
+}
+
+// The scanTodoEvents() function reads every event in a resultset
+func scanTodoEvents(rows *sql.Rows) ([]*TodoEvent, error) {
+	events := []*TodoEvent{}
+	for rows.Next() {
+		var event TodoEvent
+		var oldValues, newValues []byte
+		err := rows.Scan(
+			&event.ID,
+			&event.CreatedAt,
+			&event.TodoID,
+			&event.UserID,
+			&event.Type,
+			&oldValues,
+			&newValues,
+			&event.Version,
+			&event.ActorID,
+			&event.RequestID,
+			&event.Position,
+		)
+		if err != nil {
+			return nil, err
+		}
+		event.OldValues = oldValues
+		event.NewValues = newValues
+		events = append(events, &event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// GetAsOf() rebuilds a Task belonging to a User as it was at a moment in
+// time from the latest snapshot recorded at or before that moment
+func (m TodoEventModel) GetAsOf(todoID int64, userID int64, asOf time.Time) (*Todo, error) {
+	query := `
+		SELECT new_values
+		FROM todo_events
+		WHERE todo_id = $1
+		AND user_id = $2
+		AND created_at <= $3
+		ORDER BY id DESC
+		LIMIT 1
+	`
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	var snapshot []byte
+	err := m.DB.QueryRowContext(ctx, query, todoID, userID, asOf).Scan(&snapshot)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	// A purged Task has no snapshot
+	if snapshot == nil {
+		return nil, ErrRecordNotFound
+	}
+	todo, err := decodeTodoSnapshot(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	todo.UserID = userID
+	return todo, nil
+}
+
+// A todoSnapshot holds the state of a Task at one point in a transaction
+type todoSnapshot struct {
+	userID  int64
+	version int32
+	values  []byte
+}
+
+// The snapshotTodos() function captures the current state of a set of
+// Tasks. Tasks that no longer exist are missing from the map
+func snapshotTodos(ctx context.Context, tx *sql.Tx, ids []int64) (map[int64]todoSnapshot, error) {
+	query := `
+		SELECT id, user_id, version, to_jsonb(todos) - 'search_vector' || jsonb_build_object('tags', ARRAY(
+			SELECT tags.name FROM todo_tags INNER JOIN tags ON tags.id = todo_tags.tag_id
+			WHERE todo_tags.todo_id = todos.id ORDER BY tags.name
+		))
+		FROM todos
+		WHERE id = ANY($1)
+	`
+	rows, err := tx.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	// Close the resultset
+	defer rows.Close()
+	snapshots := make(map[int64]todoSnapshot)
+	for rows.Next() {
+		var id int64
+		var snapshot todoSnapshot
+		err := rows.Scan(&id, &snapshot.userID, &snapshot.version, &snapshot.values)
+		if err != nil {
+			return nil, err
+		}
+		snapshots[id] = snapshot
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return snapshots, nil
+}
+
+// TodoChangesChannel is the channel that the API servers are told about
+// new events on, so that each of them can wake up its own subscribers
+const TodoChangesChannel = "todo_changes"
+
+// A TodoNotification is the payload sent on TodoChangesChannel. It names
+// the newest event a transaction wrote for a User
+type TodoNotification struct {
+	UserID  int64 `json:"user_id"`
+	EventID int64 `json:"event_id"`
+}
+
+// The recordTodoEvents() function writes one todo_events row per Task,
+// pairing each snapshot taken before a change with the one taken after it.
+// Each event is added to the outbox, webhook deliveries are queued for the
+// new events, and the owners of the Tasks are notified when the
+// transaction commits
+func recordTodoEvents(ctx context.Context, tx *sql.Tx, eventType string, before, after map[int64]todoSnapshot, actorID *int64, requestID string) error {
+	query := `
+		INSERT INTO todo_events (todo_id, user_id, event_type, old_values, new_values, version, actor_id, request_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, created_at
+	`
+	// Collect every Task that appears on either side of the change
+	seen := make(map[int64]bool)
+	ids := []int64{}
+	for _, snapshots := range []map[int64]todoSnapshot{before, after} {
+		for id := range snapshots {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	latest := make(map[int64]int64)
+	eventIDs := []int64{}
+	for _, id := range ids {
+		old, hadOld := before[id]
+		current, hasNew := after[id]
+		// The version and owner come from the newest snapshot available
+		snapshot := current
+		if !hasNew {
+			snapshot = old
+		}
+		// Missing snapshots are stored as NULL
+		var oldValues, newValues interface{}
+		if hadOld {
+			oldValues = old.values
+		}
+		if hasNew {
+			newValues = current.values
+		}
+		args := []interface{}{id, snapshot.userID, eventType, oldValues, newValues, snapshot.version, actorID, requestID}
+		event := &TodoEvent{TodoID: id, UserID: snapshot.userID, Type: eventType, Version: snapshot.version}
+		if hadOld {
+			event.OldValues = old.values
+		}
+		if hasNew {
+			event.NewValues = current.values
+		}
+		err := tx.QueryRowContext(ctx, query, args...).Scan(&event.ID, &event.CreatedAt)
+		if err != nil {
+			return err
+		}
+		err = writeOutbox(ctx, tx, event)
+		if err != nil {
+			return err
+		}
+		latest[snapshot.userID] = event.ID
+		eventIDs = append(eventIDs, event.ID)
+	}
+	err := queueWebhookDeliveries(ctx, tx, eventIDs)
+	if err != nil {
+		return err
+	}
+	// NOTIFY is only delivered once the transaction commits, and not at
+	// all if it rolls back
+	for userID, eventID := range latest {
+		payload, err := json.Marshal(TodoNotification{UserID: userID, EventID: eventID})
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `SELECT pg_notify($1, $2)`, TodoChangesChannel, string(payload))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The auditTodoChange() function runs a change to a set of Tasks inside a
+// transaction and records an event for each of them in the same transaction
+func auditTodoChange(ctx context.Context, tx *sql.Tx, ids []int64, eventType string, actorID *int64, requestID string, change func() error) error {
+	before, err := snapshotTodos(ctx, tx, ids)
+	if err != nil {
+		return err
+	}
+	err = change()
+	if err != nil {
+		return err
+	}
+	after, err := snapshotTodos(ctx, tx, ids)
+	if err != nil {
+		return err
+	}
+	return recordTodoEvents(ctx, tx, eventType, before, after, actorID, requestID)
+}
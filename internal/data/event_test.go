@@ -0,0 +1,131 @@
+// Filename: internal/data/event_test.go
+
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"AWD_Quiz3.ryanarmstrong.net/internal/query"
+	"AWD_Quiz3.ryanarmstrong.net/internal/validator"
+)
+
+// An event whose transaction commits after a newer one must still be read
+// by a client that has already seen the newer one
+func TestGetAllAfterInterleavedTransactions(t *testing.T) {
+	db := newTestDB(t)
+	userID := newTestUser(t, db)
+	models := NewModels(db)
+	ctx := context.Background()
+
+	start, err := models.TodoEvents.LatestPosition()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The first transaction inserts its event, and so takes the lower id,
+	// but is still open when the second one commits
+	first, err := models.Todos.BeginTx(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Rollback()
+	early := &Todo{UserID: userID, Task: "inserted first, committed last"}
+	err = models.Todos.WithTx(first).Insert(early)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := models.Todos.BeginTx(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Rollback()
+	late := &Todo{UserID: userID, Task: "inserted last, committed first"}
+	err = models.Todos.WithTx(second).Insert(late)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = models.Todos.CommitTx(second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A client reading now only sees the second Task
+	events, err := models.TodoEvents.GetAllAfter(userID, start, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].TodoID != late.ID {
+		t.Fatalf("got %d events before the first commit; want only the event for todo %d", len(events), late.ID)
+	}
+	cursor := events[0].Position
+
+	// The first Task has the lower event id but commits afterwards, so it
+	// must come after the cursor
+	err = models.Todos.CommitTx(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, err = models.TodoEvents.GetAllAfter(userID, cursor, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].TodoID != early.ID {
+		t.Fatalf("got %d events after the cursor; want only the event for todo %d", len(events), early.ID)
+	}
+	if events[0].Position <= cursor {
+		t.Errorf("got position %d; want it after %d", events[0].Position, cursor)
+	}
+	// The WebSocket hub reads every User's events with the same cursor
+	events, err = models.TodoEvents.GetAllSince(cursor, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].TodoID != early.ID {
+		t.Fatalf("got %d events for every user after the cursor; want only the event for todo %d", len(events), early.ID)
+	}
+}
+
+// A snapshot taken with to_jsonb() holds the creation time even though a
+// Task keeps it out of its own JSON
+func TestDecodeTodoSnapshot(t *testing.T) {
+	snapshot := json.RawMessage(`{"id": 7, "created_at": "2026-10-01T09:30:00+00:00", "user_id": 3, "task": "Write report",
+		"status": "todo", "priority": 2, "tags": ["work"], "occurrence": 4, "recurrence": "FREQ=WEEKLY", "version": 5}`)
+	event := &TodoEvent{TodoID: 7, UserID: 3, Type: EventCreated, NewValues: snapshot}
+	todo, err := event.Todo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	created := time.Date(2026, time.October, 1, 9, 30, 0, 0, time.UTC)
+	if !todo.CreatedAt.Equal(created) {
+		t.Errorf("got created at %s; want %s", todo.CreatedAt, created)
+	}
+	if todo.Occurrence != 4 || todo.UserID != 3 || todo.Version != 5 || todo.Task != "Write report" {
+		t.Errorf("got %+v", todo)
+	}
+
+	tests := []struct {
+		filter string
+		match  bool
+	}{
+		{"created<2026-10-02", true},
+		{"created<2026-10-01", false},
+		{"created>2026-09-30", true},
+		{"created>2026-10-02", false},
+	}
+	for _, tt := range tests {
+		filter, err := query.Parse(tt.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v := validator.New()
+		ValidateTodoFilter(v, filter)
+		if !v.Valid() {
+			t.Fatalf("%s: %v", tt.filter, v.Errors)
+		}
+		if got := MatchTodoFilter(filter, todo); got != tt.match {
+			t.Errorf("%s: got %t; want %t", tt.filter, got, tt.match)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+// Filename: internal/data/feed.go
+
+package data
+
+import (
+	"sync"
+)
+
+// A TodoFeed tells the readers of the todo_events table that new events
+// have been committed, so that they do not have to poll for them. It only
+// carries a wake-up; the events themselves are always read from the table
+type TodoFeed struct {
+	mu          sync.Mutex
+	subscribers map[chan struct{}]struct{}
+}
+
+// NewTodoFeed() allows us to create a new TodoFeed
+func NewTodoFeed() *TodoFeed {
+	return &TodoFeed{subscribers: make(map[chan struct{}]struct{})}
+}
+
+// Subscribe() returns a channel that receives a value after new events are
+// committed, along with a function that stops the subscription
+func (f *TodoFeed) Subscribe() (<-chan struct{}, func()) {
+	// A buffer of one means a busy reader still wakes up once for all of
+	// the changes it missed
+	ch := make(chan struct{}, 1)
+	f.mu.Lock()
+	f.subscribers[ch] = struct{}{}
+	f.mu.Unlock()
+	unsubscribe := func() {
+		f.mu.Lock()
+		delete(f.subscribers, ch)
+		f.mu.Unlock()
+	}
+	return ch, unsubscribe
+}
+
+// Publish() wakes up every subscriber without waiting for any of them. It
+// is safe to call on a nil feed
+func (f *TodoFeed) Publish() {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for ch := range f.subscribers {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
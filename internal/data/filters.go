@@ -3,8 +3,12 @@
 package data
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"math"
 	"strings"
+	"time"
 
 	"AWD_Quiz3.ryanarmstrong.net/internal/validator"
 )
@@ -12,8 +16,9 @@ import (
 type Filters struct {
 	Page     int
 	PageSize int
-	Sort     string
+	Sort     []string // keys in order of precedence, "-" sorts descending
 	SortList []string
+	Cursor   string // a next_cursor value; replaces Page when set
 }
 
 func ValidateFilers(v *validator.Validator, f Filters) {
@@ -22,23 +27,72 @@ func ValidateFilers(v *validator.Validator, f Filters) {
 	v.Check(f.Page <= 1000, "page", "must be a maximum of 1000")
 	v.Check(f.PageSize > 0, "page_size", "must be greater than zero")
 	v.Check(f.PageSize <= 100, "page_size", "must be a maximum of 100")
-	// Check that the sort parameter matches a value in the acceptable sort list
-	v.Check(validator.In(f.Sort, f.SortList...), "sort", "invalid sort value")
+	// Check that each sort key matches a value in the acceptable sort list
+	v.Check(len(f.Sort) > 0, "sort", "must be provided")
+	v.Check(len(f.Sort) <= 5, "sort", "must not contain more than 5 keys")
+	columns := []string{}
+	for _, sort := range f.Sort {
+		v.Check(validator.In(sort, f.SortList...), "sort", "invalid sort value")
+		columns = append(columns, strings.TrimPrefix(sort, "-"))
+	}
+	v.Check(validator.Unique(columns), "sort", "must not contain the same key twice")
+	// Check that the cursor came from a listing with the same sort
+	if f.Cursor != "" {
+		v.Check(f.Page == 1, "cursor", "must not be used together with page")
+		c, err := decodeCursor(f.Cursor)
+		if err != nil {
+			v.AddError("cursor", "must be a next_cursor value from a previous response")
+			return
+		}
+		v.Check(c.Sort == strings.Join(f.Sort, ","), "cursor", "must be used with the same sort as the listing it came from")
+	}
+}
+
+// A cursor marks the last row of a page so that the next page can start
+// straight after it. Keys hold the sort keys of that row as text
+type cursor struct {
+	Sort string    `json:"s"`
+	Keys []string  `json:"k"`
+	ID   int64     `json:"i"`
+	Now  time.Time `json:"t"` // the moment time-based sort keys are computed at
 }
 
-// The sortColumn() method safely extracts the sort field query parameter
-func (f Filters) sortColumn() string {
+// The encodeCursor() function turns a cursor into an opaque token
+func encodeCursor(c cursor) string {
+	js, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(js)
+}
+
+// The decodeCursor() function reads a token made by encodeCursor()
+func decodeCursor(token string) (*cursor, error) {
+	js, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, err
+	}
+	var c cursor
+	err = json.Unmarshal(js, &c)
+	if err != nil {
+		return nil, err
+	}
+	if c.ID < 1 || c.Now.IsZero() {
+		return nil, errors.New("incomplete cursor")
+	}
+	return &c, nil
+}
+
+// The sortColumn() method safely extracts the field of one sort key
+func (f Filters) sortColumn(sort string) string {
 	for _, safeValue := range f.SortList {
-		if f.Sort == safeValue {
-			return strings.TrimPrefix(f.Sort, "-")
+		if sort == safeValue {
+			return strings.TrimPrefix(sort, "-")
 		}
 	}
-	panic("unsafe sort parameter: " + f.Sort)
+	panic("unsafe sort parameter: " + sort)
 }
 
-// The sortOrder() method determines whether we should sort by DESC/ASC
-func (f Filters) sortOrder() string {
-	if strings.HasPrefix(f.Sort, "-") {
+// The sortOrder() method determines whether we should sort a key by DESC/ASC
+func (f Filters) sortOrder(sort string) string {
+	if strings.HasPrefix(sort, "-") {
 		return "DESC"
 	}
 	return "ASC"
@@ -49,18 +103,36 @@ func (f Filters) limit() int {
 	return f.PageSize
 }
 
-// The offset() method calculates the OFFSET
+// The offset() method calculates the OFFSET. A cursor picks up where the
+// previous page ended so it never needs one
 func (f Filters) offset() int {
+	if f.Cursor != "" {
+		return 0
+	}
 	return (f.Page - 1) * f.PageSize
 }
 
+// The cursor() method returns the decoded cursor, or nil on the first page
+func (f Filters) cursor() *cursor {
+	if f.Cursor == "" {
+		return nil
+	}
+	// ValidateFilers() has already checked the cursor
+	c, err := decodeCursor(f.Cursor)
+	if err != nil {
+		return nil
+	}
+	return c
+}
+
 // The Metadata type contains metadata to help with pagination
 type Metadata struct {
-	CurrentPage  int `json:"current_page,omitempty"`
-	PageSize     int `json:"page_size,omitempty"`
-	FirstPage    int `json:"first_page,omitempty"`
-	LastPage     int `json:"last_page,omitempty"`
-	TotalRecords int `json:"total_records,omitempty"`
+	CurrentPage  int    `json:"current_page,omitempty"`
+	PageSize     int    `json:"page_size,omitempty"`
+	FirstPage    int    `json:"first_page,omitempty"`
+	LastPage     int    `json:"last_page,omitempty"`
+	TotalRecords int    `json:"total_records,omitempty"`
+	NextCursor   string `json:"next_cursor,omitempty"`
 }
 
 // The calculateMetadata() function computes the values for the Metadata fields
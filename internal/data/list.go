@@ -0,0 +1,288 @@
+// Filename: internal/data/list.go
+
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"AWD_Quiz3.ryanarmstrong.net/internal/validator"
+	"github.com/lib/pq"
+)
+
+// The name given to the list that orphaned Tasks are moved into
+const InboxListName = "Inbox"
+
+type List struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UserID    int64     `json:"-"` // the owner of the List
+	Name      string    `json:"name"`
+	IsInbox   bool      `json:"is_inbox"`
+	OpenCount int       `json:"open_count"` // computed, not stored
+	DoneCount int       `json:"done_count"` // computed, not stored
+	Version   int32     `json:"version"`
+}
+
+func ValidateList(v *validator.Validator, list *List) {
+	v.Check(list.Name != "", "name", "must be provided")
+	v.Check(len(list.Name) <= 100, "name", "must not be more than 100 bytes long")
+}
+
+// Define a ListModel which wraps a sql.DB connection pool
+type ListModel struct {
+	DB        *sql.DB
+	RequestID string    // recorded against Tasks changed by Delete()
+	Feed      *TodoFeed // announces committed changes to the change feed
+}
+
+// The counts are computed from the Tasks in each List
+const listCountColumns = `
+	(SELECT COUNT(*) FROM todos WHERE todos.list_id = lists.id AND todos.deleted_at IS NULL
+		AND todos.status NOT IN ('done', 'cancelled')),
+	(SELECT COUNT(*) FROM todos WHERE todos.list_id = lists.id AND todos.deleted_at IS NULL
+		AND todos.status = 'done')
+`
+
+// Insert() allows us to create a new List
+func (m ListModel) Insert(list *List) error {
+	query := `
+		INSERT INTO lists (user_id, name)
+		VALUES ($1, $2)
+		RETURNING id, created_at, is_inbox, version
+	`
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	args := []interface{}{list.UserID, list.Name}
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&list.ID, &list.CreatedAt, &list.IsInbox, &list.Version)
+}
+
+// Get() allows us to retrieve a specific List belonging to a User
+func (m ListModel) Get(id int64, userID int64) (*List, error) {
+	// Ensure that there is a valid id
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+		SELECT id, created_at, user_id, name, is_inbox, ` + listCountColumns + `, version
+		FROM lists
+		WHERE id = $1
+		AND user_id = $2
+		AND deleted_at IS NULL
+	`
+	var list List
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, id, userID).Scan(
+		&list.ID,
+		&list.CreatedAt,
+		&list.UserID,
+		&list.Name,
+		&list.IsInbox,
+		&list.OpenCount,
+		&list.DoneCount,
+		&list.Version,
+	)
+	// Handle any errors
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &list, nil
+}
+
+// GetAll() returns every List belonging to a User sorted by name
+func (m ListModel) GetAll(userID int64) ([]*List, error) {
+	query := `
+		SELECT id, created_at, user_id, name, is_inbox, ` + listCountColumns + `, version
+		FROM lists
+		WHERE user_id = $1
+		AND deleted_at IS NULL
+		ORDER BY is_inbox DESC, name ASC, id ASC
+	`
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	// Close the resultset
+	defer rows.Close()
+	lists := []*List{}
+	for rows.Next() {
+		var list List
+		err := rows.Scan(
+			&list.ID,
+			&list.CreatedAt,
+			&list.UserID,
+			&list.Name,
+			&list.IsInbox,
+			&list.OpenCount,
+			&list.DoneCount,
+			&list.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+		lists = append(lists, &list)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return lists, nil
+}
+
+// Update() allows us to rename a List
+// Optimistic locking (version number)
+func (m ListModel) Update(list *List) error {
+	query := `
+		UPDATE lists
+		SET name = $1, version = version + 1
+		WHERE id = $2
+		AND user_id = $3
+		AND version = $4
+		AND deleted_at IS NULL
+		RETURNING version
+	`
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	args := []interface{}{list.Name, list.ID, list.UserID, list.Version}
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&list.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete() removes a List. When cascade is true the Tasks in the List are
+// moved to the trash, otherwise they are moved into the User's inbox. The
+// List itself is only marked as deleted, so that restoring one of its
+// Tasks from the trash brings the List back as well
+func (m ListModel) Delete(id int64, userID int64, cascade bool) error {
+	// Ensure that there is a valid id
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// Find the live Tasks in the List
+	ids, err := queryIDs(ctx, tx, `SELECT id FROM todos WHERE list_id = $1 AND user_id = $2 AND deleted_at IS NULL`, id, userID)
+	if err != nil {
+		return err
+	}
+	query := `
+		UPDATE todos
+		SET deleted_at = NOW(), version = version + 1
+		WHERE id = ANY($1)
+	`
+	args := []interface{}{pq.Array(ids)}
+	eventType := EventDeleted
+	if !cascade {
+		inboxID, err := inboxForUser(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+		query = `
+			UPDATE todos
+			SET list_id = $2, version = version + 1
+			WHERE id = ANY($1)
+		`
+		args = append(args, inboxID)
+		eventType = EventUpdated
+	}
+	var rowsAffected int64
+	err = auditTodoChange(ctx, tx, ids, eventType, &userID, m.RequestID, func() error {
+		_, err := tx.ExecContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		// The inbox itself can never be deleted
+		result, err := tx.ExecContext(ctx, `
+			UPDATE lists
+			SET deleted_at = NOW(), version = version + 1
+			WHERE id = $1
+			AND user_id = $2
+			AND NOT is_inbox
+			AND deleted_at IS NULL`, id, userID)
+		if err != nil {
+			return err
+		}
+		rowsAffected, err = result.RowsAffected()
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	m.Feed.Publish()
+	return nil
+}
+
+// PurgeExpired() permanently removes the Lists that were deleted longer
+// than the retention period ago and no longer hold any Tasks, not even
+// ones in the trash
+func (m ListModel) PurgeExpired(retention time.Duration) (int64, error) {
+	query := `
+		DELETE FROM lists
+		WHERE deleted_at < $1
+		AND NOT EXISTS (SELECT 1 FROM todos WHERE todos.list_id = lists.id)
+	`
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, time.Now().Add(-retention))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// The inboxForUser() function returns the id of a User's inbox list,
+// creating the list if it does not exist yet
+func inboxForUser(ctx context.Context, tx *sql.Tx, userID int64) (int64, error) {
+	query := `
+		INSERT INTO lists (user_id, name, is_inbox)
+		VALUES ($1, $2, true)
+		ON CONFLICT (user_id) WHERE is_inbox DO NOTHING
+	`
+	_, err := tx.ExecContext(ctx, query, userID, InboxListName)
+	if err != nil {
+		return 0, err
+	}
+	var id int64
+	err = tx.QueryRowContext(ctx, `SELECT id FROM lists WHERE user_id = $1 AND is_inbox`, userID).Scan(&id)
+	return id, err
+}
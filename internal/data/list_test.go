@@ -0,0 +1,62 @@
+// Filename: internal/data/list_test.go
+
+package data
+
+import (
+	"errors"
+	"testing"
+)
+
+// Restoring a Task that was trashed along with its List puts it back in
+// that List, and brings the List back
+func TestRestoreTodoFromDeletedList(t *testing.T) {
+	db := newTestDB(t)
+	userID := newTestUser(t, db)
+	models := NewModels(db)
+	list := &List{UserID: userID, Name: "Groceries"}
+	err := models.Lists.Insert(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	todo := &Todo{UserID: userID, Task: "Buy milk", ListID: &list.ID}
+	err = models.Todos.Insert(todo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = models.Lists.Delete(list.ID, userID, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = models.Lists.Get(list.ID, userID)
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Fatalf("got error %v for the deleted list; want ErrRecordNotFound", err)
+	}
+	// Nothing to purge while the trashed Task still points at the List
+	purged, err := models.Lists.PurgeExpired(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purged != 0 {
+		t.Errorf("purged %d lists; want none", purged)
+	}
+
+	err = models.Todos.Restore(todo.ID, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, err := models.Todos.Get(todo.ID, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.ListID == nil || *restored.ListID != list.ID {
+		t.Errorf("got list_id %v; want %d", restored.ListID, list.ID)
+	}
+	got, err := models.Lists.Get(list.ID, userID)
+	if err != nil {
+		t.Fatalf("the list was not restored: %v", err)
+	}
+	if got.OpenCount != 1 {
+		t.Errorf("got %d open tasks in the list; want 1", got.OpenCount)
+	}
+}
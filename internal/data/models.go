@@ -3,6 +3,7 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 )
@@ -12,14 +13,48 @@ var (
 	ErrEditConflict   = errors.New("edit conflict")
 )
 
+// A querier runs queries against either a sql.DB connection pool or a
+// sql.Tx transaction
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
 // A wrapper for our data models
 type Models struct {
-	Todos TodoModel
+	Lists       ListModel
+	Outbox      OutboxModel
+	Permissions PermissionModel
+	Tags        TagModel
+	TodoEvents  TodoEventModel
+	Todos       TodoModel
+	Tokens      TokenModel
+	Users       UserModel
+	Webhooks    WebhookModel
 }
 
 // NewModels() allows us to create a new Models
 func NewModels(db *sql.DB) Models {
+	// Every model that changes Tasks announces it on the same feed
+	feed := NewTodoFeed()
 	return Models{
-		Todos: TodoModel{DB: db},
+		Lists:       ListModel{DB: db, Feed: feed},
+		Outbox:      OutboxModel{DB: db},
+		Permissions: PermissionModel{DB: db},
+		Tags:        TagModel{DB: db},
+		TodoEvents:  TodoEventModel{DB: db, Feed: feed},
+		Todos:       TodoModel{DB: db, Feed: feed},
+		Tokens:      TokenModel{DB: db},
+		Users:       UserModel{DB: db},
+		Webhooks:    WebhookModel{DB: db},
 	}
 }
+
+// WithRequestID() returns a copy of the models that records the request ID
+// against every change written to the todo_events table
+func (m Models) WithRequestID(requestID string) Models {
+	m.Todos.RequestID = requestID
+	m.Lists.RequestID = requestID
+	return m
+}
@@ -0,0 +1,214 @@
+// Filename: internal/data/outbox.go
+
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// outboxLockKey is the advisory lock held while claiming messages, so that
+// only one server claims at a time and messages leave in order
+const outboxLockKey = 7_311_011_800_240_001
+
+// An OutboxMessage is a change waiting to be published outside the API.
+// Topic names the kind of change and Key the Task it belongs to
+type OutboxMessage struct {
+	ID        int64           `json:"id"`
+	CreatedAt time.Time       `json:"created_at"`
+	Topic     string          `json:"topic"`
+	Key       string          `json:"key"`
+	Payload   json.RawMessage `json:"payload"`
+	Position  int64           `json:"-"` // the order the message was committed in
+}
+
+// Define an OutboxModel which wraps a sql.DB connection pool
+type OutboxModel struct {
+	DB *sql.DB
+}
+
+// The writeOutbox() function adds a message for an event to the outbox. It
+// runs in the transaction that wrote the event, so the message exists if
+// and only if the change was committed
+func writeOutbox(ctx context.Context, tx *sql.Tx, event *TodoEvent) error {
+	change, err := event.Change()
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(change)
+	if err != nil {
+		return err
+	}
+	query := `
+		INSERT INTO outbox (topic, key, payload)
+		VALUES ($1, $2, $3)
+	`
+	_, err = tx.ExecContext(ctx, query, "todo."+event.Type, strconv.FormatInt(event.TodoID, 10), payload)
+	return err
+}
+
+// Relay() claims up to limit undispatched messages, in the order they were
+// committed, and
+// hands them to dispatch one at a time outside of any transaction. Each
+// message is marked as dispatched as soon as dispatch accepts it. Relay
+// stops at the first message dispatch fails on, so that no message
+// overtakes another, and returns the number dispatched along with the
+// error. The claim is renewed after every message, so lease only has to
+// cover dispatching one of them. Nothing happens while another server
+// holds a claim
+func (m OutboxModel) Relay(limit int, lease time.Duration, dispatch func(*OutboxMessage) error) (int, error) {
+	messages, err := m.claim(limit, lease)
+	if err != nil {
+		return 0, err
+	}
+	ids := make([]int64, len(messages))
+	for i, message := range messages {
+		ids[i] = message.ID
+	}
+	for i, message := range messages {
+		err = dispatch(message)
+		if err != nil {
+			// Give up the rest of the claim so that the failed message is
+			// retried first. If that fails too the claim runs out by itself
+			return i, errors.Join(err, m.release(ids[i:]))
+		}
+		err = m.markDispatched(message.ID, ids[i+1:], lease)
+		if err != nil {
+			return i, err
+		}
+	}
+	return len(messages), nil
+}
+
+// The claim() method takes up to limit undispatched messages, in the order
+// they were committed, until the lease runs out. Only one server claims at a time, and nothing
+// is claimed while an earlier claim is still live, so batches never
+// overlap and a relay that dies part way through does not lose them
+func (m OutboxModel) claim(limit int, lease time.Duration) ([]*OutboxMessage, error) {
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	var locked bool
+	err = tx.QueryRowContext(ctx, `SELECT pg_try_advisory_xact_lock($1)`, outboxLockKey).Scan(&locked)
+	if err != nil || !locked {
+		return nil, err
+	}
+	query := `
+		UPDATE outbox
+		SET claimed_until = NOW() + $2::double precision * INTERVAL '1 millisecond'
+		WHERE id IN (
+			SELECT id
+			FROM outbox
+			WHERE dispatched_at IS NULL
+			AND NOT EXISTS (
+				SELECT 1 FROM outbox AS claimed
+				WHERE claimed.dispatched_at IS NULL
+				AND claimed.claimed_until > NOW()
+			)
+			ORDER BY position ASC
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, created_at, topic, key, payload, position
+	`
+	rows, err := tx.QueryContext(ctx, query, limit, lease.Milliseconds())
+	if err != nil {
+		return nil, err
+	}
+	messages := []*OutboxMessage{}
+	for rows.Next() {
+		var message OutboxMessage
+		var payload []byte
+		err := rows.Scan(
+			&message.ID,
+			&message.CreatedAt,
+			&message.Topic,
+			&message.Key,
+			&payload,
+			&message.Position,
+		)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		message.Payload = payload
+		messages = append(messages, &message)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	// RETURNING does not keep the order of the subquery
+	sort.Slice(messages, func(i, j int) bool { return messages[i].Position < messages[j].Position })
+	return messages, nil
+}
+
+// The markDispatched() method records that a message has been dispatched
+// and renews the claim on the messages still waiting behind it
+func (m OutboxModel) markDispatched(id int64, waiting []int64, lease time.Duration) error {
+	query := `
+		UPDATE outbox
+		SET dispatched_at = CASE WHEN id = $1 THEN NOW() ELSE dispatched_at END,
+			claimed_until = CASE WHEN id = $1 THEN NULL ELSE NOW() + $3::double precision * INTERVAL '1 millisecond' END
+		WHERE id = $1
+		OR id = ANY($2)
+	`
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, id, pq.Array(waiting), lease.Milliseconds())
+	return err
+}
+
+// The release() method gives up the claim on messages that have not been
+// dispatched so that the next relay can take them
+func (m OutboxModel) release(ids []int64) error {
+	query := `
+		UPDATE outbox
+		SET claimed_until = NULL
+		WHERE id = ANY($1)
+		AND dispatched_at IS NULL
+	`
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, pq.Array(ids))
+	return err
+}
+
+// DeleteDispatched() removes the messages that were dispatched longer ago
+// than the retention period and reports how many went
+func (m OutboxModel) DeleteDispatched(retention time.Duration) (int64, error) {
+	query := `
+		DELETE FROM outbox
+		WHERE dispatched_at < $1
+	`
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, time.Now().Add(-retention))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
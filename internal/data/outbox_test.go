@@ -0,0 +1,117 @@
+// Filename: internal/data/outbox_test.go
+
+package data
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// A message that fails holds up the ones behind it, and is the first one
+// handed out by the next relay
+func TestRelayStopsAtFailure(t *testing.T) {
+	db := newTestDB(t)
+	userID := newTestUser(t, db)
+	models := NewModels(db)
+	for _, task := range []string{"first", "second", "third"} {
+		err := models.Todos.Insert(&Todo{UserID: userID, Task: task})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	seen := []int64{}
+	count, err := models.Outbox.Relay(10, time.Minute, func(message *OutboxMessage) error {
+		if len(seen) == 1 {
+			return errors.New("sink unavailable")
+		}
+		seen = append(seen, message.ID)
+		return nil
+	})
+	if count != 1 || err == nil {
+		t.Fatalf("got %d dispatched and error %v; want 1 dispatched and an error", count, err)
+	}
+
+	// The claim on the failed message was given up, so it is retried
+	// straight away and in order
+	retried := []int64{}
+	count, err = models.Outbox.Relay(10, time.Minute, func(message *OutboxMessage) error {
+		retried = append(retried, message.ID)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 || len(retried) != 2 || retried[0] <= seen[0] || retried[1] <= retried[0] {
+		t.Fatalf("got %v after %v; want the two remaining messages in order", retried, seen)
+	}
+
+	// Nothing is left to relay
+	count, err = models.Outbox.Relay(10, time.Minute, func(message *OutboxMessage) error {
+		t.Errorf("message %d was relayed twice", message.ID)
+		return nil
+	})
+	if count != 0 || err != nil {
+		t.Fatalf("got %d dispatched and error %v; want nothing", count, err)
+	}
+}
+
+// A message whose transaction commits after a newer one has been relayed
+// must still be relayed, after it
+func TestRelayInterleavedTransactions(t *testing.T) {
+	db := newTestDB(t)
+	userID := newTestUser(t, db)
+	models := NewModels(db)
+	ctx := context.Background()
+
+	// The first transaction inserts its message, and so takes the lower
+	// id, but is still open when the second one commits
+	first, err := models.Todos.BeginTx(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Rollback()
+	err = models.Todos.WithTx(first).Insert(&Todo{UserID: userID, Task: "inserted first, committed last"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := models.Todos.BeginTx(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Rollback()
+	err = models.Todos.WithTx(second).Insert(&Todo{UserID: userID, Task: "inserted last, committed first"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = models.Todos.CommitTx(second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	relayed := []*OutboxMessage{}
+	relay := func(message *OutboxMessage) error {
+		relayed = append(relayed, message)
+		return nil
+	}
+	count, err := models.Outbox.Relay(10, time.Minute, relay)
+	if err != nil || count != 1 {
+		t.Fatalf("got %d relayed and error %v before the first commit; want 1", count, err)
+	}
+
+	err = models.Todos.CommitTx(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	count, err = models.Outbox.Relay(10, time.Minute, relay)
+	if err != nil || count != 1 {
+		t.Fatalf("got %d relayed and error %v after the first commit; want 1", count, err)
+	}
+	// The message with the lower id went out second, in commit order
+	if relayed[1].ID >= relayed[0].ID || relayed[1].Position <= relayed[0].Position {
+		t.Errorf("got ids %d, %d at positions %d, %d; want the lower id at the later position",
+			relayed[0].ID, relayed[1].ID, relayed[0].Position, relayed[1].Position)
+	}
+}
@@ -0,0 +1,92 @@
+// Filename: internal/data/permission.go
+
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Permission codes
+const (
+	PermissionTodosRead  = "todos:read"
+	PermissionTodosWrite = "todos:write"
+)
+
+// DefaultPermissions are granted to every newly registered User
+var DefaultPermissions = []string{PermissionTodosRead, PermissionTodosWrite}
+
+// Permissions holds the permission codes for a single User
+type Permissions []string
+
+// The Include() method checks if a permission code is in the slice
+func (p Permissions) Include(code string) bool {
+	for i := range p {
+		if code == p[i] {
+			return true
+		}
+	}
+	return false
+}
+
+// Define a PermissionModel which wraps a sql.DB connection pool
+type PermissionModel struct {
+	DB *sql.DB
+}
+
+// GetAllForUser() returns every permission code granted to a User
+func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
+	query := `
+		SELECT permissions.code
+		FROM permissions
+		INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
+		WHERE users_permissions.user_id = $1
+	`
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	// Close the resultset
+	defer rows.Close()
+	var permissions Permissions
+	for rows.Next() {
+		var permission string
+		err := rows.Scan(&permission)
+		if err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, permission)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return permissions, nil
+}
+
+// AddForUser() grants one or more permission codes to a User
+func (m PermissionModel) AddForUser(userID int64, codes ...string) error {
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	return addPermissions(ctx, m.DB, userID, codes)
+}
+
+// The addPermissions() function grants permission codes to a User, either
+// directly or inside a transaction
+func addPermissions(ctx context.Context, q querier, userID int64, codes []string) error {
+	query := `
+		INSERT INTO users_permissions
+		SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
+		ON CONFLICT DO NOTHING
+	`
+	_, err := q.ExecContext(ctx, query, userID, pq.Array(codes))
+	return err
+}
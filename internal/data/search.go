@@ -0,0 +1,222 @@
+// Filename: internal/data/search.go
+
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"AWD_Quiz3.ryanarmstrong.net/internal/validator"
+	"github.com/lib/pq"
+)
+
+// searchLanguages maps the text search configs a search can use onto the
+// indexed tsvector expression for that config
+var searchLanguages = map[string]string{
+	"simple":  "search_vector",
+	"english": "to_tsvector('english', task)",
+}
+
+// headlineOptions wraps every match in a ts_headline snippet in <mark> tags
+const headlineOptions = "StartSel=<mark>, StopSel=</mark>, HighlightAll=true"
+
+// escapedTask is the task with its HTML special characters escaped. A
+// headline is HTML, so the task has to be escaped before the <mark> tags
+// go in or a task could carry markup of its own to the client
+const escapedTask = `replace(replace(replace(replace(replace(task, '&', '&amp;'), '<', '&lt;'), '>', '&gt;'), '"', '&quot;'), '''', '&#39;')`
+
+// DefaultMinSimilarity is the word similarity a fuzzy match needs when the
+// client does not choose one
+const DefaultMinSimilarity = 0.3
+
+// A TodoSearch holds the text a client is searching Tasks for
+type TodoSearch struct {
+	Query         string
+	Language      string  // one of the keys of searchLanguages
+	Prefix        bool    // match words that start with the search terms
+	Fuzzy         bool    // match by trigram similarity, which tolerates typos
+	MinSimilarity float64 // the lowest similarity a fuzzy match may have
+}
+
+// A SearchResult is a Task that matched a search, with how well it matched
+// and its text, HTML escaped, with the matches highlighted. Fuzzy matches
+// cannot be highlighted, so their headline is the escaped task
+type SearchResult struct {
+	Todo       *Todo    `json:"todo"`
+	Rank       float64  `json:"rank"`
+	Similarity *float64 `json:"similarity,omitempty"` // only set for fuzzy searches
+	Headline   string   `json:"headline"`
+}
+
+// ValidateTodoSearch() checks the text and language of a search
+func ValidateTodoSearch(v *validator.Validator, search TodoSearch) {
+	v.Check(search.Query != "", "q", "must be provided")
+	v.Check(len(search.Query) <= 200, "q", "must not be more than 200 bytes long")
+	if search.Query != "" {
+		v.Check(len(searchTerms(search.Query)) > 0, "q", "must contain at least one letter or digit")
+	}
+	languages := []string{}
+	for language := range searchLanguages {
+		languages = append(languages, language)
+	}
+	v.Check(validator.In(search.Language, languages...), "language", "must be simple or english")
+	if search.Fuzzy {
+		ValidateMinSimilarity(v, search.MinSimilarity)
+	}
+}
+
+// ValidateMinSimilarity() checks the threshold of a fuzzy match
+func ValidateMinSimilarity(v *validator.Validator, minSimilarity float64) {
+	v.Check(minSimilarity > 0, "min_similarity", "must be greater than zero")
+	v.Check(minSimilarity <= 1, "min_similarity", "must not be more than 1")
+}
+
+// The searchTerms() function splits a search into words, dropping anything
+// that to_tsquery() would read as an operator
+func searchTerms(text string) []string {
+	return strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// The tsquery() method returns the SQL that builds the search query from
+// $1, along with the value for $1. Prefix searches match the start of each
+// word; otherwise the web search syntax of quotes, "or" and "-" applies
+func (search TodoSearch) tsquery() (string, string) {
+	if !search.Prefix {
+		return fmt.Sprintf("websearch_to_tsquery('%s', $1)", search.Language), search.Query
+	}
+	terms := searchTerms(search.Query)
+	for i, term := range terms {
+		terms[i] = term + ":*"
+	}
+	return fmt.Sprintf("to_tsquery('%s', $1)", search.Language), strings.Join(terms, " & ")
+}
+
+// The withSimilarityThreshold() method returns a transaction in which the
+// <% operator matches at the given word similarity, so that fuzzy matches
+// can use the trigram index. finish must be called once the rows are read
+func (m TodoModel) withSimilarityThreshold(ctx context.Context, threshold float64) (*sql.Tx, func(), error) {
+	tx := m.Tx
+	finish := func() {}
+	if tx == nil {
+		var err error
+		tx, err = m.DB.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+		if err != nil {
+			return nil, nil, err
+		}
+		finish = func() { tx.Rollback() }
+	}
+	// The setting only lasts until the end of the transaction
+	_, err := tx.ExecContext(ctx, `SELECT set_config('pg_trgm.word_similarity_threshold', $1, true)`, strconv.FormatFloat(threshold, 'f', -1, 64))
+	if err != nil {
+		finish()
+		return nil, nil, err
+	}
+	return tx, finish, nil
+}
+
+// fuzzySearchQuery finds Tasks that contain something like $1
+const fuzzySearchQuery = `
+	SELECT COUNT(*) OVER(), word_similarity($1, task) AS rank, ` + escapedTask + `,
+		id, created_at, user_id, list_id, parent_id, task, status, priority, ARRAY(
+			SELECT tags.name FROM todo_tags INNER JOIN tags ON tags.id = todo_tags.tag_id
+			WHERE todo_tags.todo_id = todos.id ORDER BY tags.name
+		), start_at, due_at, completed_at, recurrence, occurrence, next_occurrence_at, deleted_at, version
+	FROM todos
+	WHERE user_id = $2
+	AND deleted_at IS NULL
+	AND $1 <% task
+	ORDER BY rank DESC, id ASC
+	LIMIT $3 OFFSET $4`
+
+// Search() returns the live Tasks belonging to a User that match a search,
+// best match first
+func (m TodoModel) Search(userID int64, search TodoSearch, filters Filters) ([]*SearchResult, Metadata, error) {
+	// The language has been checked against searchLanguages
+	vector := searchLanguages[search.Language]
+	tsquery, terms := search.tsquery()
+	query := fmt.Sprintf(`
+		SELECT COUNT(*) OVER(), ts_rank(%[1]s, search_query) AS rank, ts_headline('%[3]s', %[5]s, search_query, '%[4]s'),
+			id, created_at, user_id, list_id, parent_id, task, status, priority, ARRAY(
+				SELECT tags.name FROM todo_tags INNER JOIN tags ON tags.id = todo_tags.tag_id
+				WHERE todo_tags.todo_id = todos.id ORDER BY tags.name
+			), start_at, due_at, completed_at, recurrence, occurrence, next_occurrence_at, deleted_at, version
+		FROM todos, %[2]s AS search_query
+		WHERE user_id = $2
+		AND deleted_at IS NULL
+		AND %[1]s @@ search_query
+		ORDER BY rank DESC, id ASC
+		LIMIT $3 OFFSET $4`, vector, tsquery, search.Language, headlineOptions, escapedTask)
+	// A fuzzy search ranks by word similarity instead
+	if search.Fuzzy {
+		terms = search.Query
+		query = fuzzySearchQuery
+	}
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	q := m.querier()
+	if search.Fuzzy {
+		tx, finish, err := m.withSimilarityThreshold(ctx, search.MinSimilarity)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		defer finish()
+		q = tx
+	}
+	rows, err := q.QueryContext(ctx, query, terms, userID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	// Close the resultset
+	defer rows.Close()
+	totalRecords := 0
+	results := []*SearchResult{}
+	for rows.Next() {
+		var todo Todo
+		var result SearchResult
+		err := rows.Scan(
+			&totalRecords,
+			&result.Rank,
+			&result.Headline,
+			&todo.ID,
+			&todo.CreatedAt,
+			&todo.UserID,
+			&todo.ListID,
+			&todo.ParentID,
+			&todo.Task,
+			&todo.Status,
+			&todo.Priority,
+			pq.Array(&todo.Tags),
+			&todo.StartAt,
+			&todo.DueAt,
+			&todo.CompletedAt,
+			&todo.Recurrence,
+			&todo.Occurrence,
+			&todo.NextOccurrenceAt,
+			&todo.DeletedAt,
+			&todo.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		todo.setOverdue()
+		if search.Fuzzy {
+			result.Similarity = &result.Rank
+		}
+		result.Todo = &todo
+		results = append(results, &result)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return results, metadata, nil
+}
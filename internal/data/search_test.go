@@ -0,0 +1,46 @@
+// Filename: internal/data/search_test.go
+
+package data
+
+import "testing"
+
+// Markup in a task must reach the client escaped, with only the <mark>
+// tags of the headline left as HTML
+func TestSearchEscapesHeadline(t *testing.T) {
+	db := newTestDB(t)
+	userID := newTestUser(t, db)
+	models := NewModels(db)
+	todo := &Todo{UserID: userID, Task: `Report <img src=x onerror="alert('x')"> & notes`}
+	err := models.Todos.Insert(todo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		search TodoSearch
+		want   string
+	}{
+		{
+			search: TodoSearch{Query: "report", Language: "simple"},
+			want:   `<mark>Report</mark> &lt;img src=x onerror=&quot;alert(&#39;x&#39;)&quot;&gt; &amp; notes`,
+		},
+		{
+			search: TodoSearch{Query: "reprot", Language: "simple", Fuzzy: true, MinSimilarity: 0.1},
+			want:   `Report &lt;img src=x onerror=&quot;alert(&#39;x&#39;)&quot;&gt; &amp; notes`,
+		},
+	}
+	for _, tt := range tests {
+		results, _, err := models.Todos.Search(userID, tt.search, Filters{Page: 1, PageSize: 20})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 1 {
+			t.Fatalf("%q: got %d results; want 1", tt.search.Query, len(results))
+		}
+		if results[0].Headline != tt.want {
+			t.Errorf("%q: got headline %s; want %s", tt.search.Query, results[0].Headline, tt.want)
+		}
+		if results[0].Todo.Task != todo.Task {
+			t.Errorf("%q: got task %s; want it unescaped", tt.search.Query, results[0].Todo.Task)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+// Filename: internal/data/tag.go
+
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Tag reports how many of a User's Tasks carry a given tag
+type Tag struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// Define a TagModel which wraps a sql.DB connection pool
+type TagModel struct {
+	DB *sql.DB
+}
+
+// GetAllForUser() returns every tag a User has created with its usage count
+func (m TagModel) GetAllForUser(userID int64) ([]*Tag, error) {
+	query := `
+		SELECT tags.name, COUNT(todos.id)
+		FROM tags
+		LEFT JOIN todo_tags ON todo_tags.tag_id = tags.id
+		LEFT JOIN todos ON todos.id = todo_tags.todo_id AND todos.deleted_at IS NULL
+		WHERE tags.user_id = $1
+		GROUP BY tags.name
+		ORDER BY COUNT(todos.id) DESC, tags.name ASC
+	`
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	// Close the resultset
+	defer rows.Close()
+	tags := []*Tag{}
+	for rows.Next() {
+		var tag Tag
+		err := rows.Scan(&tag.Name, &tag.Count)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, &tag)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+// The replaceTodoTags() function makes the tags stored for a Task match
+// todo.Tags, creating any tags the User has not used before
+func replaceTodoTags(ctx context.Context, tx *sql.Tx, todo *Todo) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM todo_tags WHERE todo_id = $1`, todo.ID)
+	if err != nil {
+		return err
+	}
+	if len(todo.Tags) == 0 {
+		return nil
+	}
+	query := `
+		INSERT INTO tags (user_id, name)
+		SELECT $1, unnest($2::text[])
+		ON CONFLICT (user_id, name) DO NOTHING
+	`
+	_, err = tx.ExecContext(ctx, query, todo.UserID, pq.Array(todo.Tags))
+	if err != nil {
+		return err
+	}
+	query = `
+		INSERT INTO todo_tags (todo_id, tag_id)
+		SELECT $1, tags.id FROM tags
+		WHERE tags.user_id = $2 AND tags.name = ANY($3)
+	`
+	_, err = tx.ExecContext(ctx, query, todo.ID, todo.UserID, pq.Array(todo.Tags))
+	return err
+}
@@ -0,0 +1,93 @@
+// Filename: internal/data/testutils_test.go
+
+package data
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// The newTestDB() function connects to the database named by the
+// TODO_TEST_DB_DSN environment variable and runs every migration in a
+// schema of its own, which is dropped when the test finishes. Tests that
+// need a database are skipped when the variable is not set
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	dsn := os.Getenv("TODO_TEST_DB_DSN")
+	if dsn == "" {
+		t.Skip("TODO_TEST_DB_DSN is not set")
+	}
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		var err error
+		dsn, err = pq.ParseURL(dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer admin.Close()
+	schema := fmt.Sprintf("test_%d_%d", os.Getpid(), time.Now().UnixNano())
+	_, err = admin.Exec(`CREATE SCHEMA ` + schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every connection in the pool starts out in the new schema. Extensions
+	// that are already installed stay visible through public
+	db, err := sql.Open("postgres", dsn+" search_path="+schema+",public")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		admin, err := sql.Open("postgres", dsn)
+		if err != nil {
+			return
+		}
+		defer admin.Close()
+		admin.Exec(`DROP SCHEMA ` + schema + ` CASCADE`)
+	})
+	files, err := filepath.Glob("../../migrations/*.up.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		migration, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = db.Exec(string(migration))
+		if err != nil {
+			t.Fatalf("%s: %v", filepath.Base(file), err)
+		}
+	}
+	return db
+}
+
+// The newTestUser() function creates a User to own the Tasks in a test
+func newTestUser(t *testing.T, db *sql.DB) int64 {
+	t.Helper()
+	query := `
+		INSERT INTO users (name, email, password_hash)
+		VALUES ($1, $2, $3)
+		RETURNING id
+	`
+	var id int64
+	email := fmt.Sprintf("user%d@example.com", time.Now().UnixNano())
+	err := db.QueryRow(query, "Test User", email, []byte("not a real hash")).Scan(&id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
@@ -7,63 +7,423 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"reflect"
+	"strings"
 	"time"
 
+	"AWD_Quiz3.ryanarmstrong.net/internal/query"
+	"AWD_Quiz3.ryanarmstrong.net/internal/recurrence"
 	"AWD_Quiz3.ryanarmstrong.net/internal/validator"
+	"github.com/lib/pq"
 )
 
+// The lifecycle states a Task can be in
+const (
+	StatusTodo       = "todo"
+	StatusInProgress = "in_progress"
+	StatusBlocked    = "blocked"
+	StatusDone       = "done"
+	StatusCancelled  = "cancelled"
+)
+
+// Priorities run from PriorityNone up to PriorityUrgent
+const (
+	PriorityNone   = 0
+	PriorityUrgent = 4
+)
+
+// StatusList holds every valid status in lifecycle order
+var StatusList = []string{StatusTodo, StatusInProgress, StatusBlocked, StatusDone, StatusCancelled}
+
+// statusTransitions lists the states a Task may move to from each state
+var statusTransitions = map[string][]string{
+	StatusTodo:       {StatusInProgress, StatusBlocked, StatusDone, StatusCancelled},
+	StatusInProgress: {StatusTodo, StatusBlocked, StatusDone, StatusCancelled},
+	StatusBlocked:    {StatusTodo, StatusInProgress, StatusCancelled},
+	StatusDone:       {StatusTodo},
+	StatusCancelled:  {StatusTodo},
+}
+
 type Todo struct {
-	ID        int64     `json:"id"` // Struct tags
-	CreatedAt time.Time `json:"-"`  // doesn't display to client
-	Task      string    `json:"task"`
-	Complete  string    `json:"complete"`
-	Version   int32     `json:"version"`
+	ID               int64      `json:"id"` // Struct tags
+	CreatedAt        time.Time  `json:"-"`  // doesn't display to client
+	UserID           int64      `json:"-"`  // the owner of the Task
+	ListID           *int64     `json:"list_id,omitempty"`
+	ParentID         *int64     `json:"parent_id,omitempty"`
+	Task             string     `json:"task"`
+	Status           string     `json:"status"`
+	Priority         int        `json:"priority"`
+	Tags             []string   `json:"tags"`
+	StartAt          *time.Time `json:"start_at,omitempty"`
+	DueAt            *time.Time `json:"due_at,omitempty"`
+	IsOverdue        bool       `json:"is_overdue"` // computed, not stored
+	CompletedAt      *time.Time `json:"completed_at,omitempty"`
+	Recurrence       string     `json:"recurrence,omitempty"`
+	Occurrence       int32      `json:"-"` // position of the Task in its recurring series
+	NextOccurrenceAt *time.Time `json:"next_occurrence_at,omitempty"`
+	DeletedAt        *time.Time `json:"deleted_at,omitempty"` // set while the Task is in the trash
+	Version          int32      `json:"version"`
+	NextTodo         *Todo      `json:"next_todo,omitempty"`  // created when a recurring Task is done
+	Subtasks         []*Todo    `json:"subtasks,omitempty"`   // only filled in by showTodoHandler
+	Progress         *int       `json:"progress,omitempty"`   // percentage of subtasks done
+	Similarity       *float64   `json:"similarity,omitempty"` // only filled in by fuzzy listings
+}
+
+// TodoFields() lists the JSON names of the fields of a Task, which are
+// the names clients may use to pick the fields they want returned
+func TodoFields() []string {
+	fields := []string{}
+	t := reflect.TypeOf(Todo{})
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			fields = append(fields, name)
+		}
+	}
+	return fields
+}
+
+// The setOverdue() method computes the IsOverdue field. A Task is overdue
+// when its due date has passed and it is neither done nor cancelled
+func (todo *Todo) setOverdue() {
+	todo.IsOverdue = todo.DueAt != nil &&
+		todo.DueAt.Before(time.Now()) &&
+		todo.Status != StatusDone &&
+		todo.Status != StatusCancelled
+}
+
+// The recurrenceAnchor() method returns the time a recurring series is
+// counted from: the due date, the start date or the creation time
+func (todo *Todo) recurrenceAnchor() time.Time {
+	switch {
+	case todo.DueAt != nil:
+		return *todo.DueAt
+	case todo.StartAt != nil:
+		return *todo.StartAt
+	case todo.CreatedAt.IsZero():
+		return time.Now()
+	}
+	return todo.CreatedAt
+}
+
+// The setNextOccurrence() method computes the NextOccurrenceAt field from the
+// recurrence rule. It is nil for one-off Tasks and finished series
+func (todo *Todo) setNextOccurrence() {
+	todo.NextOccurrenceAt = nil
+	if todo.Recurrence == "" {
+		return
+	}
+	rule, err := recurrence.Parse(todo.Recurrence)
+	if err != nil {
+		return
+	}
+	if next, ok := rule.Next(todo.recurrenceAnchor(), int(todo.Occurrence)); ok {
+		todo.NextOccurrenceAt = &next
+	}
+}
+
+// The Occurrences() method previews up to limit upcoming occurrences
+func (todo *Todo) Occurrences(limit int) []time.Time {
+	rule, err := recurrence.Parse(todo.Recurrence)
+	if err != nil {
+		return []time.Time{}
+	}
+	return rule.Occurrences(todo.recurrenceAnchor(), int(todo.Occurrence), limit)
+}
+
+// The SetProgress() method computes the percentage of subtasks that are
+// done. Cancelled subtasks do not count towards the total
+func (todo *Todo) SetProgress() {
+	if len(todo.Subtasks) == 0 {
+		todo.Progress = nil
+		return
+	}
+	total, done := 0, 0
+	for _, subtask := range todo.Subtasks {
+		switch subtask.Status {
+		case StatusCancelled:
+			continue
+		case StatusDone:
+			done++
+		}
+		total++
+	}
+	progress := 100
+	if total > 0 {
+		progress = done * 100 / total
+	}
+	todo.Progress = &progress
+}
+
+// TodoFilters holds the criteria used by GetAll() to narrow a listing
+type TodoFilters struct {
+	Task      string
+	Status    []string // empty matches every status
+	DueBefore *time.Time
+	DueAfter  *time.Time
+	Overdue   bool
+	Tags      []string // matches Tasks with any of these tags
+	TagsAll   []string // matches Tasks with all of these tags
+	ListID    *int64
+	Priority  []int64    // empty matches every priority
+	Trashed   bool       // list the trash instead of live Tasks
+	Filter    query.Node // a parsed "filter" expression, nil for none
+	Fuzzy     bool       // match Task by trigram similarity instead of words
+	// MinSimilarity is the lowest word similarity a fuzzy match may have
+	MinSimilarity float64
+}
+
+// todoFilterFields are the fields that a "filter" expression can compare
+var todoFilterFields = query.Schema{
+	"id":        {Column: "id", Type: query.Int},
+	"task":      {Column: "task", Type: query.String},
+	"status":    {Column: "status", Type: query.Enum, Values: StatusList},
+	"complete":  {Column: "(status = 'done')", Type: query.Bool},
+	"priority":  {Column: "priority::bigint", Type: query.Int},
+	"list":      {Column: "list_id", Type: query.Int},
+	"parent":    {Column: "parent_id", Type: query.Int},
+	"recurring": {Column: "(recurrence <> '')", Type: query.Bool},
+	"created":   {Column: "created_at", Type: query.Time},
+	"start":     {Column: "start_at", Type: query.Time},
+	"due":       {Column: "due_at", Type: query.Time},
+	"completed": {Column: "completed_at", Type: query.Time},
+}
+
+// ValidateTodoFilter() checks a parsed "filter" expression against the
+// fields that Tasks can be filtered on
+func ValidateTodoFilter(v *validator.Validator, filter query.Node) {
+	query.Validate(v, "filter", filter, todoFilterFields)
+}
+
+// MatchTodoFilter() reports if a Task matches a validated "filter"
+// expression without going to the database
+func MatchTodoFilter(filter query.Node, todo *Todo) bool {
+	values := query.Values{
+		"id":        todo.ID,
+		"task":      todo.Task,
+		"status":    todo.Status,
+		"complete":  todo.Status == StatusDone,
+		"priority":  int64(todo.Priority),
+		"recurring": todo.Recurrence != "",
+		"created":   todo.CreatedAt,
+	}
+	// Unset fields are left out so that they never match, as NULL would
+	if todo.ListID != nil {
+		values["list"] = *todo.ListID
+	}
+	if todo.ParentID != nil {
+		values["parent"] = *todo.ParentID
+	}
+	for field, value := range map[string]*time.Time{"start": todo.StartAt, "due": todo.DueAt, "completed": todo.CompletedAt} {
+		if value != nil {
+			values[field] = *value
+		}
+	}
+	return query.Match(filter, todoFilterFields, values)
+}
+
+// The compileTodoFilter() function turns a validated "filter" expression
+// into SQL whose parameters are numbered from next
+func compileTodoFilter(filter query.Node, next int) (string, []interface{}) {
+	if filter == nil {
+		return "true", nil
+	}
+	return query.Compile(filter, todoFilterFields, next)
 }
 
 func ValidateTodo(v *validator.Validator, todo *Todo) {
 	// Use the Check() method to execute our validation checks
 	v.Check(todo.Task != "", "task", "must be provided")
 	v.Check(len(todo.Task) <= 200, "task", "must not be more than 200 bytes long")
+	v.Check(validator.In(todo.Status, StatusList...), "status", "must be one of todo, in_progress, blocked, done or cancelled")
+	v.Check(todo.Priority >= PriorityNone && todo.Priority <= PriorityUrgent, "priority", "must be between 0 and 4")
+	// A Task cannot start after it is due
+	if todo.StartAt != nil && todo.DueAt != nil {
+		v.Check(!todo.StartAt.After(*todo.DueAt), "start_at", "must not be after due_at")
+	}
+	ValidateTags(v, "tags", todo.Tags)
+	if todo.Recurrence != "" {
+		_, err := recurrence.Parse(todo.Recurrence)
+		v.Check(err == nil, "recurrence", "must be an RRULE using FREQ, INTERVAL, BYDAY, BYMONTHDAY, COUNT or UNTIL")
+	}
+}
 
-	//v.Check(todo.Complete != "", "complete", "must be provided")
-	//v.Check(len(todo.Complete) <= 200, "complete", "must not be more than 200 bytes long")
+// ValidateTags() checks a list of tag names supplied under the given key
+func ValidateTags(v *validator.Validator, key string, tags []string) {
+	v.Check(len(tags) <= 20, key, "must not contain more than 20 tags")
+	v.Check(validator.Unique(tags), key, "must not contain duplicate values")
+	for _, tag := range tags {
+		v.Check(tag != "", key, "must not contain empty tags")
+		v.Check(len(tag) <= 50, key, "must not contain tags more than 50 bytes long")
+	}
+}
 
+// ValidateStatusTransition() checks that a Task is allowed to move from
+// one status to another. Staying in the same status is always allowed
+func ValidateStatusTransition(v *validator.Validator, from string, to string) {
+	if from == to {
+		return
+	}
+	v.Check(validator.In(to, statusTransitions[from]...), "status", fmt.Sprintf("cannot move from %s to %s", from, to))
 }
 
 // Define a TodoModel which wraps a sql.DB connection pool
 type TodoModel struct {
-	DB *sql.DB
+	DB        *sql.DB
+	Tx        *sql.Tx   // set by WithTx() to share one transaction between calls
+	RequestID string    // recorded against every change in the todo_events table
+	Feed      *TodoFeed // announces committed changes to the change feed
+}
+
+// BeginTx() starts a transaction that several TodoModel calls can share
+// through WithTx()
+func (m TodoModel) BeginTx(ctx context.Context) (*sql.Tx, error) {
+	return m.DB.BeginTx(ctx, nil)
+}
+
+// CommitTx() commits a transaction started by BeginTx() and announces the
+// changes made in it on the feed
+func (m TodoModel) CommitTx(tx *sql.Tx) error {
+	err := tx.Commit()
+	if err != nil {
+		return err
+	}
+	m.Feed.Publish()
+	return nil
+}
+
+// WithTx() returns a copy of the model that runs every method inside tx.
+// The caller is responsible for committing it with CommitTx() or rolling
+// it back
+func (m TodoModel) WithTx(tx *sql.Tx) TodoModel {
+	m.Tx = tx
+	return m
+}
+
+// The querier() method returns the shared transaction when there is one
+// so that reads see the changes made earlier in it
+func (m TodoModel) querier() querier {
+	if m.Tx != nil {
+		return m.Tx
+	}
+	return m.DB
+}
+
+// The begin() method starts a transaction for a single change. Inside a
+// shared transaction the change runs in a savepoint instead, so that a
+// failed change is undone without aborting the shared transaction
+func (m TodoModel) begin(ctx context.Context) (*sql.Tx, func() error, func(), error) {
+	if m.Tx == nil {
+		tx, err := m.DB.BeginTx(ctx, nil)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		commit := func() error {
+			return m.CommitTx(tx)
+		}
+		return tx, commit, func() { tx.Rollback() }, nil
+	}
+	tx := m.Tx
+	_, err := tx.ExecContext(ctx, `SAVEPOINT todo_change`)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	released := false
+	commit := func() error {
+		_, err := tx.ExecContext(ctx, `RELEASE SAVEPOINT todo_change`)
+		released = err == nil
+		return err
+	}
+	rollback := func() {
+		if !released {
+			tx.ExecContext(ctx, `ROLLBACK TO SAVEPOINT todo_change`)
+		}
+	}
+	return tx, commit, rollback, nil
 }
 
 // Insert() allows us to create a new Task
 func (m TodoModel) Insert(todo *Todo) error {
-	query := `
-		INSERT INTO todos (task)
-		VALUES ($1)
-		RETURNING id, created_at, version, complete
-	`
 	// Create a context
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	// Cleanup to prevent memory leaks
 	defer cancel()
+	// The Task and its tags are written in a single transaction
+	tx, commit, rollback, err := m.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer rollback()
+	err = m.insert(ctx, tx, todo)
+	if err != nil {
+		return err
+	}
+	return commit()
+}
+
+// The insert() method writes a new Task, its tags and its created event
+// using an existing transaction
+func (m TodoModel) insert(ctx context.Context, tx *sql.Tx, todo *Todo) error {
+	query := `
+		INSERT INTO todos (user_id, list_id, parent_id, task, priority, start_at, due_at, recurrence, occurrence, next_occurrence_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		RETURNING id, created_at, version, status, completed_at
+	`
+	// The first Task in a series is occurrence number one
+	if todo.Occurrence < 1 {
+		todo.Occurrence = 1
+	}
+	todo.setNextOccurrence()
 	// Collect the data fields into a slice
 	args := []interface{}{
+		todo.UserID,
+		todo.ListID,
+		todo.ParentID,
 		todo.Task,
+		todo.Priority,
+		todo.StartAt,
+		todo.DueAt,
+		todo.Recurrence,
+		todo.Occurrence,
+		todo.NextOccurrenceAt,
+	}
+	err := tx.QueryRowContext(ctx, query, args...).Scan(&todo.ID, &todo.CreatedAt, &todo.Version, &todo.Status, &todo.CompletedAt)
+	if err != nil {
+		return err
+	}
+	err = replaceTodoTags(ctx, tx, todo)
+	if err != nil {
+		return err
+	}
+	// A new Task has nothing to compare against
+	after, err := snapshotTodos(ctx, tx, []int64{todo.ID})
+	if err != nil {
+		return err
+	}
+	err = recordTodoEvents(ctx, tx, EventCreated, nil, after, &todo.UserID, m.RequestID)
+	if err != nil {
+		return err
 	}
-	return m.DB.QueryRowContext(ctx, query, args...).Scan(&todo.ID, &todo.CreatedAt, &todo.Version, &todo.Complete)
+	todo.setOverdue()
+	return nil
 }
 
-// Get() allows us to recieve a specific Task
-func (m TodoModel) Get(id int64) (*Todo, error) {
+// Get() allows us to recieve a specific Task belonging to a User
+func (m TodoModel) Get(id int64, userID int64) (*Todo, error) {
 	// Ensure that there is a valid id
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
 	// Create the query
 	query := `
-		SELECT id, created_at, task, complete, version
+		SELECT id, created_at, user_id, list_id, parent_id, task, status, priority, ARRAY(
+			SELECT tags.name FROM todo_tags INNER JOIN tags ON tags.id = todo_tags.tag_id
+			WHERE todo_tags.todo_id = todos.id ORDER BY tags.name
+		), start_at, due_at, completed_at, recurrence, occurrence, next_occurrence_at, deleted_at, version
 		FROM todos
 		WHERE id = $1
+		AND user_id = $2
+		AND deleted_at IS NULL
 	`
 	// Declare a Todo variable to hold the returned data
 	var todo Todo
@@ -72,11 +432,23 @@ func (m TodoModel) Get(id int64) (*Todo, error) {
 	// Cleanup to prevent memory leaks
 	defer cancel()
 	// Execute the query using QueryRow()
-	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+	err := m.querier().QueryRowContext(ctx, query, id, userID).Scan(
 		&todo.ID,
 		&todo.CreatedAt,
+		&todo.UserID,
+		&todo.ListID,
+		&todo.ParentID,
 		&todo.Task,
-		&todo.Complete,
+		&todo.Status,
+		&todo.Priority,
+		pq.Array(&todo.Tags),
+		&todo.StartAt,
+		&todo.DueAt,
+		&todo.CompletedAt,
+		&todo.Recurrence,
+		&todo.Occurrence,
+		&todo.NextOccurrenceAt,
+		&todo.DeletedAt,
 		&todo.Version,
 	)
 	// Handle any errors
@@ -89,33 +461,64 @@ func (m TodoModel) Get(id int64) (*Todo, error) {
 			return nil, err
 		}
 	}
+	todo.setOverdue()
 	// Success
 	return &todo, nil
 }
 
 // Update() allows us to edit/alter a specific Task
 // Optimistic locking (version number)
+// The completed_at timestamp is set when the Task enters the done state
+// and cleared when it leaves it. Completing a recurring Task creates the
+// next occurrence in the same transaction, but only the first time it is
+// completed
 func (m TodoModel) Update(todo *Todo) error {
 	// Create a query
 	query := `
 		UPDATE todos
-		SET task = $1, complete = $2, version = version + 1
-		WHERE id = $3
-		AND version = $4
-		RETURNING version
+		SET task = $1, status = $2, start_at = $3, due_at = $4, list_id = $8, parent_id = $9,
+			recurrence = $10, next_occurrence_at = $11, priority = $12, version = version + 1,
+			completed_at = CASE
+				WHEN $2 <> 'done' THEN NULL
+				WHEN status = 'done' THEN completed_at
+				ELSE NOW()
+			END
+		WHERE id = $5
+		AND user_id = $6
+		AND version = $7
+		AND deleted_at IS NULL
+		RETURNING version, completed_at
 	`
 	// Create a context
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	// Cleanup to prevent memory leaks
 	defer cancel()
+	todo.setNextOccurrence()
 	args := []interface{}{
 		todo.Task,
-		todo.Complete,
+		todo.Status,
+		todo.StartAt,
+		todo.DueAt,
 		todo.ID,
+		todo.UserID,
 		todo.Version,
+		todo.ListID,
+		todo.ParentID,
+		todo.Recurrence,
+		todo.NextOccurrenceAt,
+		todo.Priority,
+	}
+	// The Task and its tags are written in a single transaction
+	tx, commit, rollback, err := m.begin(ctx)
+	if err != nil {
+		return err
 	}
-	// Check for edit conflicts
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&todo.Version)
+	defer rollback()
+	// Lock the row and find out which status the Task is leaving, and if
+	// it has already created its next occurrence
+	var previousStatus string
+	var nextTodoID *int64
+	err = tx.QueryRowContext(ctx, `SELECT status, next_todo_id FROM todos WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL FOR UPDATE`, todo.ID, todo.UserID).Scan(&previousStatus, &nextTodoID)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -124,59 +527,437 @@ func (m TodoModel) Update(todo *Todo) error {
 			return err
 		}
 	}
-	return nil
+	err = auditTodoChange(ctx, tx, []int64{todo.ID}, EventUpdated, &todo.UserID, m.RequestID, func() error {
+		// Check for edit conflicts
+		err := tx.QueryRowContext(ctx, query, args...).Scan(&todo.Version, &todo.CompletedAt)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrEditConflict
+			default:
+				return err
+			}
+		}
+		return replaceTodoTags(ctx, tx, todo)
+	})
+	if err != nil {
+		return err
+	}
+	// Completing a recurring Task creates its next occurrence. A Task that
+	// is reopened and completed again already has one
+	todo.NextTodo = nil
+	if previousStatus != StatusDone && todo.Status == StatusDone && todo.NextOccurrenceAt != nil && nextTodoID == nil {
+		todo.NextTodo = todo.nextOccurrence()
+		err = m.insert(ctx, tx, todo.NextTodo)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `UPDATE todos SET next_todo_id = $1 WHERE id = $2`, todo.NextTodo.ID, todo.ID)
+		if err != nil {
+			return err
+		}
+	}
+	todo.setOverdue()
+	return commit()
+}
+
+// The nextOccurrence() method builds the Task that follows a recurring
+// Task. Its dates are shifted so that it falls on NextOccurrenceAt
+func (todo *Todo) nextOccurrence() *Todo {
+	next := &Todo{
+		UserID:     todo.UserID,
+		ListID:     todo.ListID,
+		ParentID:   todo.ParentID,
+		Task:       todo.Task,
+		Status:     StatusTodo,
+		Priority:   todo.Priority,
+		Tags:       todo.Tags,
+		Recurrence: todo.Recurrence,
+		Occurrence: todo.Occurrence + 1,
+	}
+	shift := todo.NextOccurrenceAt.Sub(todo.recurrenceAnchor())
+	if todo.StartAt != nil {
+		startAt := todo.StartAt.Add(shift)
+		next.StartAt = &startAt
+	}
+	if todo.DueAt != nil {
+		dueAt := todo.DueAt.Add(shift)
+		next.DueAt = &dueAt
+	}
+	// Without dates the series would be counted from the creation time
+	if next.StartAt == nil && next.DueAt == nil {
+		startAt := *todo.NextOccurrenceAt
+		next.StartAt = &startAt
+	}
+	return next
 }
 
-// Delete() removes a specific Task
-func (m TodoModel) Delete(id int64) error {
+// Delete() moves a specific Task belonging to a User, along with its
+// subtasks, into the trash. When version is not nil the Task is only
+// deleted if it is still at that version
+func (m TodoModel) Delete(id int64, userID int64, version *int32) error {
 	// Ensure that there is a valid id
 	if id < 1 {
 		return ErrRecordNotFound
 	}
-	// Create the delete query
+	// Find the Task and every live subtask below it
+	subtree := `
+		WITH RECURSIVE subtree (id) AS (
+			SELECT id FROM todos WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL
+			UNION
+			SELECT todos.id FROM todos
+			INNER JOIN subtree ON todos.parent_id = subtree.id
+			WHERE todos.deleted_at IS NULL
+		)
+		SELECT id FROM subtree
+	`
+	// Create the delete query. The whole subtree shares one deleted_at
+	// so that Restore() can bring it back together
+	query := `
+		UPDATE todos
+		SET deleted_at = NOW(), version = version + 1
+		WHERE id = ANY($1)
+	`
+	// The Task at the root is only trashed if it is still at the version
+	// the client expects
+	if version != nil {
+		query += `AND (id <> $2 OR version = $3)`
+	}
+	return m.changeSubtree(subtree, query, EventDeleted, id, userID, version)
+}
+
+// Restore() brings a Task back out of the trash along with the subtasks
+// that were deleted with it. Parents that are still in the trash are
+// brought back as well, as a purge of the parent would take the Task with
+// it. A List that was deleted along with them is brought back too
+func (m TodoModel) Restore(id int64, userID int64) error {
+	// Ensure that there is a valid id
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+	// Find the Task, the subtasks that were trashed at the same time and
+	// the trashed parents above it
+	subtree := `
+		WITH RECURSIVE subtree (id, deleted_at) AS (
+			SELECT id, deleted_at FROM todos WHERE id = $1 AND user_id = $2 AND deleted_at IS NOT NULL
+			UNION
+			SELECT todos.id, todos.deleted_at FROM todos
+			INNER JOIN subtree ON todos.parent_id = subtree.id
+			WHERE todos.deleted_at = subtree.deleted_at
+		),
+		ancestors (id, parent_id) AS (
+			SELECT id, parent_id FROM todos WHERE id = $1 AND user_id = $2 AND deleted_at IS NOT NULL
+			UNION
+			SELECT todos.id, todos.parent_id FROM todos
+			INNER JOIN ancestors ON todos.id = ancestors.parent_id
+			WHERE todos.deleted_at IS NOT NULL
+		)
+		SELECT id FROM subtree
+		UNION
+		SELECT id FROM ancestors
+	`
+	query := `
+		WITH restored AS (
+			UPDATE todos
+			SET deleted_at = NULL, version = version + 1
+			WHERE id = ANY($1)
+			RETURNING list_id
+		)
+		UPDATE lists
+		SET deleted_at = NULL, version = version + 1
+		WHERE id IN (SELECT list_id FROM restored)
+		AND deleted_at IS NOT NULL
+	`
+	return m.changeSubtree(subtree, query, EventRestored, id, userID, nil)
+}
+
+// Purge() permanently removes a Task that is in the trash. Its subtasks
+// go with it
+func (m TodoModel) Purge(id int64, userID int64) error {
+	// Ensure that there is a valid id
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+	// Find the Task and every subtask that the delete will cascade to
+	subtree := `
+		WITH RECURSIVE subtree (id) AS (
+			SELECT id FROM todos WHERE id = $1 AND user_id = $2 AND deleted_at IS NOT NULL
+			UNION
+			SELECT todos.id FROM todos
+			INNER JOIN subtree ON todos.parent_id = subtree.id
+		)
+		SELECT id FROM subtree
+	`
 	query := `
 		DELETE FROM todos
-		WHERE id = $1
+		WHERE id = ANY($1)
 	`
+	return m.changeSubtree(subtree, query, EventPurged, id, userID, nil)
+}
+
+// The changeSubtree() method finds a set of Tasks with the subtree query,
+// applies the change query to them and records an event for each one, all
+// in a single transaction. When version is not nil it is passed to the
+// change query along with the id of the Task at the root, and the change
+// is an edit conflict unless every Task in the subtree was changed
+func (m TodoModel) changeSubtree(subtree string, query string, eventType string, id int64, userID int64, version *int32) error {
 	// Create a context
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	// Cleanup to prevent memory leaks
 	defer cancel()
-	// Execute the query
-	result, err := m.DB.ExecContext(ctx, query, id)
+	tx, commit, rollback, err := m.begin(ctx)
 	if err != nil {
 		return err
 	}
-	// Check how many rows were affected by the delete operation. We
-	// call the RowsAffected() method on the result variable
-	rowsAffected, err := result.RowsAffected()
+	defer rollback()
+	ids, err := queryIDs(ctx, tx, subtree, id, userID)
 	if err != nil {
 		return err
 	}
-	// Check if no rows were affected
-	if rowsAffected == 0 {
+	// Check if there was nothing to change
+	if len(ids) == 0 {
 		return ErrRecordNotFound
 	}
-	return nil
+	err = auditTodoChange(ctx, tx, ids, eventType, &userID, m.RequestID, func() error {
+		if version == nil {
+			_, err := tx.ExecContext(ctx, query, pq.Array(ids))
+			return err
+		}
+		result, err := tx.ExecContext(ctx, query, pq.Array(ids), id, *version)
+		if err != nil {
+			return err
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		// Check for edit conflicts
+		if rowsAffected < int64(len(ids)) {
+			return ErrEditConflict
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return commit()
+}
+
+// PurgeExpired() permanently removes every Task that has been in the
+// trash for longer than the retention period and reports how many went
+func (m TodoModel) PurgeExpired(retention time.Duration) (int64, error) {
+	// Subtasks are purged along with expired parents
+	subtree := `
+		WITH RECURSIVE subtree (id) AS (
+			SELECT id FROM todos WHERE deleted_at < $1 AND user_id IS NOT NULL
+			UNION
+			SELECT todos.id FROM todos
+			INNER JOIN subtree ON todos.parent_id = subtree.id
+		)
+		SELECT id FROM subtree
+	`
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	ids, err := queryIDs(ctx, tx, subtree, time.Now().Add(-retention))
+	if err != nil || len(ids) == 0 {
+		return 0, err
+	}
+	// There is no actor for a system purge
+	err = auditTodoChange(ctx, tx, ids, EventPurged, nil, m.RequestID, func() error {
+		_, err := tx.ExecContext(ctx, `DELETE FROM todos WHERE id = ANY($1)`, pq.Array(ids))
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(ids)), m.CommitTx(tx)
 }
 
-// the GetAll() method returns a list of all the tasks sorted by id
-func (m TodoModel) GetAll(task string, complete string, filters Filters) ([]*Todo, Metadata, error) {
+// The queryIDs() function runs a query that returns a single id column
+func queryIDs(ctx context.Context, tx *sql.Tx, query string, args ...interface{}) ([]int64, error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	// Close the resultset
+	defer rows.Close()
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		err := rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// todoSortExpressions maps sort keys that are not plain columns onto the
+// SQL expression used to order them. Missing dates sort as if they were
+// infinitely far away so that every key can be compared in a cursor
+var todoSortExpressions = map[string]string{
+	"status":     "array_position(ARRAY['todo', 'in_progress', 'blocked', 'done', 'cancelled'], status)",
+	"complete":   "(status = 'done')",
+	"due_at":     "COALESCE(due_at, 'infinity')",
+	"deleted_at": "COALESCE(deleted_at, 'infinity')",
+	"smart":      "-(" + urgencyScore + ")",
+}
+
+// todoSortTypes holds the SQL type of each sort key, which is needed to
+// read a key back out of a cursor
+var todoSortTypes = map[string]string{
+	"id":         "bigint",
+	"created_at": "timestamptz",
+	"task":       "text",
+	"status":     "integer",
+	"complete":   "boolean",
+	"due_at":     "timestamptz",
+	"deleted_at": "timestamptz",
+	"priority":   "smallint",
+	"smart":      "numeric",
+}
+
+// urgencyScore ranks Tasks by priority first, then by how active they are,
+// with older Tasks gaining up to ten points as they age over a month.
+// Closed Tasks always sink to the bottom. The age is measured from $16 so
+// that every page of a cursor listing sees the same scores
+const urgencyScore = `priority * 10
+	+ CASE status WHEN 'in_progress' THEN 6 WHEN 'todo' THEN 4 WHEN 'blocked' THEN 2 ELSE -100 END
+	+ LEAST(EXTRACT(EPOCH FROM $16::timestamptz - created_at) / 86400, 30) / 3`
+
+// the GetAll() method returns a list of all the tasks belonging to a User
+// sorted by id
+func (m TodoModel) GetAll(userID int64, criteria TodoFilters, filters Filters) ([]*Todo, Metadata, error) {
+	// Work out what we are ordering by. Ties are always broken by id in
+	// ascending order
+	orderBy := []string{}
+	sortKeys := []string{}
+	// A cursor continues after the last row of the previous page. The row
+	// comes later if it is after the cursor on the first key that differs
+	after := []string{}
+	equal := []string{}
+	for i, sort := range filters.Sort {
+		key := filters.sortColumn(sort)
+		column := key
+		if expression, ok := todoSortExpressions[key]; ok {
+			column = expression
+		}
+		orderBy = append(orderBy, column+" "+filters.sortOrder(sort))
+		sortKeys = append(sortKeys, fmt.Sprintf("(%s)::text", column))
+		comparison := ">"
+		if filters.sortOrder(sort) == "DESC" {
+			comparison = "<"
+		}
+		value := fmt.Sprintf("($14::text[])[%d]::%s", i+1, todoSortTypes[key])
+		after = append(after, strings.Join(append(equal, fmt.Sprintf("%s %s %s", column, comparison, value)), " AND "))
+		equal = append(equal, fmt.Sprintf("%s = %s", column, value))
+	}
+	orderBy = append(orderBy, "id ASC")
+	after = append(after, strings.Join(append(equal, "id > $15"), " AND "))
+	// Only the smart sort refers to $16. The values of the filter
+	// expression come after the fixed parameters
+	next := 16
+	if strings.Contains(strings.Join(orderBy, ""), "$16") {
+		next = 17
+	}
+	filter, filterArgs := compileTodoFilter(criteria.Filter, next)
+	// Counting every match is skipped when paging by cursor
+	count := "COUNT(*) OVER()"
+	if filters.Cursor != "" {
+		count = "0"
+	}
+	// A fuzzy match on the task uses the trigram index and reports how
+	// similar each Task is
+	similarity := "NULL::real"
+	match := "search_vector @@ plainto_tsquery('simple', $1)"
+	if criteria.Fuzzy {
+		similarity = "word_similarity($1, task)"
+		match = "$1 <% task"
+	}
 	// Construct the query
 	query := fmt.Sprintf(`
-		SELECT COUNT(*) OVER(), id, created_at, task, complete, version
+		SELECT %s, ARRAY[%s], %s, id, created_at, user_id, list_id, parent_id, task, status, priority, ARRAY(
+			SELECT tags.name FROM todo_tags INNER JOIN tags ON tags.id = todo_tags.tag_id
+			WHERE todo_tags.todo_id = todos.id ORDER BY tags.name
+		), start_at, due_at, completed_at, recurrence, occurrence, next_occurrence_at, deleted_at, version
 		FROM todos
-		WHERE (to_tsvector('simple', task) @@ plainto_tsquery('simple', $1) OR $1 = '')
-		AND (to_tsvector('simple', complete) @@ plainto_tsquery('simple', $2) OR $2 = '')
-		ORDER BY %s %s, id ASC
-		LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortOrder())
+		WHERE user_id = $8
+		AND (%s OR $1 = '')
+		AND (status = ANY($2) OR cardinality($2::text[]) = 0)
+		AND (due_at < $3 OR $3::timestamptz IS NULL)
+		AND (due_at > $4 OR $4::timestamptz IS NULL)
+		AND (NOT $5 OR (due_at < NOW() AND status NOT IN ('done', 'cancelled')))
+		AND (cardinality($9::text[]) = 0 OR EXISTS (
+			SELECT 1 FROM todo_tags INNER JOIN tags ON tags.id = todo_tags.tag_id
+			WHERE todo_tags.todo_id = todos.id AND tags.name = ANY($9)
+		))
+		AND (cardinality($10::text[]) = 0 OR cardinality($10::text[]) = (
+			SELECT COUNT(*) FROM todo_tags INNER JOIN tags ON tags.id = todo_tags.tag_id
+			WHERE todo_tags.todo_id = todos.id AND tags.name = ANY($10)
+		))
+		AND (list_id = $11 OR $11::bigint IS NULL)
+		AND (priority = ANY($12) OR cardinality($12::smallint[]) = 0)
+		AND (deleted_at IS NOT NULL) = $13
+		AND ($15::bigint IS NULL OR (%s))
+		AND %s
+		ORDER BY %s
+		LIMIT $6 OFFSET $7`, count, strings.Join(sortKeys, ", "), similarity, match, strings.Join(after, ") OR ("), filter, strings.Join(orderBy, ", "))
 
 	// Create a 3-seconds-timeout context
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	// Execute the query
-	args := []interface{}{task, complete, filters.limit(), filters.offset()}
-	rows, err := m.DB.QueryContext(ctx, query, args...)
+	// Time-based sort keys are computed at the moment the first page was read
+	now := time.Now()
+	var cursorKeys []string
+	var cursorID *int64
+	if c := filters.cursor(); c != nil {
+		now = c.Now
+		cursorKeys = c.Keys
+		cursorID = &c.ID
+	}
+	// Execute the query. One extra row tells us if there is a next page
+	args := []interface{}{
+		criteria.Task,
+		pq.Array(criteria.Status),
+		criteria.DueBefore,
+		criteria.DueAfter,
+		criteria.Overdue,
+		filters.limit() + 1,
+		filters.offset(),
+		userID,
+		pq.Array(criteria.Tags),
+		pq.Array(criteria.TagsAll),
+		criteria.ListID,
+		pq.Array(criteria.Priority),
+		criteria.Trashed,
+		pq.Array(cursorKeys),
+		cursorID,
+	}
+	if next == 17 {
+		args = append(args, now)
+	}
+	args = append(args, filterArgs...)
+	q := m.querier()
+	if criteria.Fuzzy {
+		tx, finish, err := m.withSimilarityThreshold(ctx, criteria.MinSimilarity)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		defer finish()
+		q = tx
+	}
+	rows, err := q.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, Metadata{}, err
 	}
@@ -185,29 +966,155 @@ func (m TodoModel) GetAll(task string, complete string, filters Filters) ([]*Tod
 	totalRecords := 0
 	// Initialize an empty slice to hold the Todo data
 	todos := []*Todo{}
+	keys := [][]string{}
 	// Iterate over the rows in the resultset
 	for rows.Next() {
 		var todo Todo
+		var key []string
 		// Scan the values from the row into the task
 		err := rows.Scan(
 			&totalRecords,
+			pq.Array(&key),
+			&todo.Similarity,
 			&todo.ID,
 			&todo.CreatedAt,
+			&todo.UserID,
+			&todo.ListID,
+			&todo.ParentID,
 			&todo.Task,
-			&todo.Complete,
+			&todo.Status,
+			&todo.Priority,
+			pq.Array(&todo.Tags),
+			&todo.StartAt,
+			&todo.DueAt,
+			&todo.CompletedAt,
+			&todo.Recurrence,
+			&todo.Occurrence,
+			&todo.NextOccurrenceAt,
+			&todo.DeletedAt,
 			&todo.Version,
 		)
 		if err != nil {
 			return nil, Metadata{}, err
 		}
+		todo.setOverdue()
 		// Add the Todo to our slice
 		todos = append(todos, &todo)
+		keys = append(keys, key)
 	}
 	// Check for errors after looping through the resultset
 	if err = rows.Err(); err != nil {
 		return nil, Metadata{}, err
 	}
 	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	if filters.Cursor != "" {
+		metadata = Metadata{PageSize: filters.PageSize}
+	}
+	// The extra row only tells us that there is a next page
+	if len(todos) > filters.limit() {
+		todos = todos[:filters.limit()]
+		last := len(todos) - 1
+		metadata.NextCursor = encodeCursor(cursor{Sort: strings.Join(filters.Sort, ","), Keys: keys[last], ID: todos[last].ID, Now: now})
+	}
 	// Return the slice of Forums
 	return todos, metadata, nil
 }
+
+// GetSubtasks() returns every direct subtask of a Task sorted by id
+func (m TodoModel) GetSubtasks(parentID int64, userID int64) ([]*Todo, error) {
+	query := `
+		SELECT id, created_at, user_id, list_id, parent_id, task, status, priority, ARRAY(
+			SELECT tags.name FROM todo_tags INNER JOIN tags ON tags.id = todo_tags.tag_id
+			WHERE todo_tags.todo_id = todos.id ORDER BY tags.name
+		), start_at, due_at, completed_at, recurrence, occurrence, next_occurrence_at, deleted_at, version
+		FROM todos
+		WHERE parent_id = $1
+		AND user_id = $2
+		AND deleted_at IS NULL
+		ORDER BY id ASC
+	`
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	rows, err := m.querier().QueryContext(ctx, query, parentID, userID)
+	if err != nil {
+		return nil, err
+	}
+	// Close the resultset
+	defer rows.Close()
+	todos := []*Todo{}
+	for rows.Next() {
+		var todo Todo
+		err := rows.Scan(
+			&todo.ID,
+			&todo.CreatedAt,
+			&todo.UserID,
+			&todo.ListID,
+			&todo.ParentID,
+			&todo.Task,
+			&todo.Status,
+			&todo.Priority,
+			pq.Array(&todo.Tags),
+			&todo.StartAt,
+			&todo.DueAt,
+			&todo.CompletedAt,
+			&todo.Recurrence,
+			&todo.Occurrence,
+			&todo.NextOccurrenceAt,
+			&todo.DeletedAt,
+			&todo.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+		todo.setOverdue()
+		todos = append(todos, &todo)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return todos, nil
+}
+
+// CountOpenSubtasks() returns how many direct subtasks of a Task are
+// neither done nor cancelled
+func (m TodoModel) CountOpenSubtasks(parentID int64, userID int64) (int, error) {
+	query := `
+		SELECT COUNT(*)
+		FROM todos
+		WHERE parent_id = $1
+		AND user_id = $2
+		AND status NOT IN ('done', 'cancelled')
+		AND deleted_at IS NULL
+	`
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	var count int
+	err := m.querier().QueryRowContext(ctx, query, parentID, userID).Scan(&count)
+	return count, err
+}
+
+// CreatesCycle() checks if making parentID the parent of a Task would
+// create a cycle, which happens when the Task is the parent or one of
+// its ancestors
+func (m TodoModel) CreatesCycle(id int64, parentID int64) (bool, error) {
+	query := `
+		WITH RECURSIVE ancestors (id, parent_id) AS (
+			SELECT id, parent_id FROM todos WHERE id = $2
+			UNION
+			SELECT todos.id, todos.parent_id FROM todos
+			INNER JOIN ancestors ON todos.id = ancestors.parent_id
+		)
+		SELECT EXISTS (SELECT 1 FROM ancestors WHERE id = $1)
+	`
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	var cycle bool
+	err := m.querier().QueryRowContext(ctx, query, id, parentID).Scan(&cycle)
+	return cycle, err
+}
@@ -0,0 +1,164 @@
+// Filename: internal/data/todo_test.go
+
+package data
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// Reopening a completed recurring Task and completing it again must not
+// create a second copy of its next occurrence
+func TestUpdateReopenRecurringTodo(t *testing.T) {
+	db := newTestDB(t)
+	userID := newTestUser(t, db)
+	models := NewModels(db)
+	dueAt := time.Date(2030, time.January, 6, 9, 0, 0, 0, time.UTC)
+	todo := &Todo{UserID: userID, Task: "Water the plants", DueAt: &dueAt, Recurrence: "FREQ=WEEKLY"}
+	err := models.Todos.Insert(todo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	todo.Status = StatusDone
+	err = models.Todos.Update(todo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if todo.NextTodo == nil {
+		t.Fatal("completing the task did not create its next occurrence")
+	}
+	want := dueAt.AddDate(0, 0, 7)
+	if todo.NextTodo.DueAt == nil || !todo.NextTodo.DueAt.Equal(want) {
+		t.Errorf("got the next occurrence due at %v; want %s", todo.NextTodo.DueAt, want)
+	}
+	next := todo.NextTodo.ID
+
+	// Reopen it and complete it again
+	todo.Status = StatusTodo
+	err = models.Todos.Update(todo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	todo.Status = StatusDone
+	err = models.Todos.Update(todo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if todo.NextTodo != nil {
+		t.Errorf("completing the task again created another occurrence, todo %d", todo.NextTodo.ID)
+	}
+
+	var count int
+	err = db.QueryRow(`SELECT COUNT(*) FROM todos WHERE user_id = $1`, userID).Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("got %d tasks; want the task and its one next occurrence", count)
+	}
+	var nextTodoID int64
+	err = db.QueryRow(`SELECT next_todo_id FROM todos WHERE id = $1`, todo.ID).Scan(&nextTodoID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nextTodoID != next {
+		t.Errorf("got next_todo_id %d; want %d", nextTodoID, next)
+	}
+}
+
+// A delete that names an old version must leave the Task and its
+// subtasks alone
+func TestDeleteWithStaleVersion(t *testing.T) {
+	db := newTestDB(t)
+	userID := newTestUser(t, db)
+	models := NewModels(db)
+	todo := &Todo{UserID: userID, Task: "Plan the trip"}
+	err := models.Todos.Insert(todo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	subtask := &Todo{UserID: userID, Task: "Book the hotel", ParentID: &todo.ID}
+	err = models.Todos.Insert(subtask)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale := todo.Version
+	todo.Task = "Plan the holiday"
+	err = models.Todos.Update(todo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = models.Todos.Delete(todo.ID, userID, &stale)
+	if !errors.Is(err, ErrEditConflict) {
+		t.Fatalf("got error %v; want ErrEditConflict", err)
+	}
+	var trashed int
+	err = db.QueryRow(`SELECT COUNT(*) FROM todos WHERE user_id = $1 AND deleted_at IS NOT NULL`, userID).Scan(&trashed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trashed != 0 {
+		t.Errorf("got %d tasks in the trash; want none", trashed)
+	}
+
+	err = models.Todos.Delete(todo.ID, userID, &todo.Version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.QueryRow(`SELECT COUNT(*) FROM todos WHERE user_id = $1 AND deleted_at IS NOT NULL`, userID).Scan(&trashed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trashed != 2 {
+		t.Errorf("got %d tasks in the trash; want the task and its subtask", trashed)
+	}
+}
+
+// Restoring a subtask whose parent is in the trash restores the parent
+// too, so that purging the parent cannot take the subtask with it
+func TestRestoreSubtaskThenPurgeParent(t *testing.T) {
+	db := newTestDB(t)
+	userID := newTestUser(t, db)
+	models := NewModels(db)
+	parent := &Todo{UserID: userID, Task: "Move house"}
+	err := models.Todos.Insert(parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	child := &Todo{UserID: userID, Task: "Pack the books", ParentID: &parent.ID}
+	err = models.Todos.Insert(child)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = models.Todos.Delete(parent.ID, userID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = models.Todos.Restore(child.ID, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []int64{parent.ID, child.ID} {
+		_, err = models.Todos.Get(id, userID)
+		if err != nil {
+			t.Errorf("todo %d was not restored: %v", id, err)
+		}
+	}
+	// The parent is no longer in the trash, so there is nothing to purge
+	err = models.Todos.Purge(parent.ID, userID)
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("got error %v purging the parent; want ErrRecordNotFound", err)
+	}
+	_, err = models.Todos.PurgeExpired(-time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = models.Todos.Get(child.ID, userID)
+	if err != nil {
+		t.Errorf("the restored subtask was purged: %v", err)
+	}
+}
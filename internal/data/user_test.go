@@ -0,0 +1,51 @@
+// Filename: internal/data/user_test.go
+
+package data
+
+import (
+	"errors"
+	"testing"
+)
+
+// A User is created together with its permissions, and a failed insert
+// leaves neither behind
+func TestInsertUserWithPermissions(t *testing.T) {
+	db := newTestDB(t)
+	models := NewModels(db)
+	user := &User{Name: "Test User", Email: "insert@example.com"}
+	err := user.Password.Set("pa55word1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = models.Users.Insert(user, DefaultPermissions...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	permissions, err := models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, code := range DefaultPermissions {
+		if !permissions.Include(code) {
+			t.Errorf("got permissions %v; want %s among them", permissions, code)
+		}
+	}
+
+	duplicate := &User{Name: "Test User", Email: "insert@example.com"}
+	err = duplicate.Password.Set("pa55word1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = models.Users.Insert(duplicate, DefaultPermissions...)
+	if !errors.Is(err, ErrDuplicateEmail) {
+		t.Fatalf("got error %v; want ErrDuplicateEmail", err)
+	}
+	var count int
+	err = db.QueryRow(`SELECT COUNT(*) FROM users_permissions`).Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != len(DefaultPermissions) {
+		t.Errorf("got %d granted permissions; want %d", count, len(DefaultPermissions))
+	}
+}
@@ -0,0 +1,488 @@
+// Filename: internal/data/webhook.go
+
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"time"
+
+	"AWD_Quiz3.ryanarmstrong.net/internal/validator"
+	"github.com/lib/pq"
+)
+
+// The states a webhook delivery can be in
+const (
+	DeliveryPending   = "pending"   // waiting for its first or next attempt
+	DeliveryDelivered = "delivered" // the receiver accepted it
+	DeliveryFailed    = "failed"    // every attempt failed and we gave up
+)
+
+// A Webhook POSTs the changes to a User's Tasks to a URL. The secret is
+// used to sign every delivery
+type Webhook struct {
+	ID           int64      `json:"id"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UserID       int64      `json:"-"` // the owner of the Webhook
+	URL          string     `json:"url"`
+	Secret       string     `json:"secret,omitempty"` // only sent when the Webhook is created
+	EventTypes   []string   `json:"event_types"`      // empty for every kind of event
+	Active       bool       `json:"active"`           // computed, not stored
+	FailureCount int        `json:"failure_count"`    // failed attempts since the last success
+	DisabledAt   *time.Time `json:"disabled_at,omitempty"`
+	Version      int32      `json:"version"`
+}
+
+// A WebhookDelivery is a single event queued for a Webhook, along with the
+// outcome of its latest attempt
+type WebhookDelivery struct {
+	ID             int64      `json:"id"`
+	CreatedAt      time.Time  `json:"created_at"`
+	WebhookID      int64      `json:"webhook_id"`
+	EventID        int64      `json:"event_id"`
+	EventType      string     `json:"event_type"`
+	Status         string     `json:"status"`
+	Attempts       int        `json:"attempts"`
+	NextAttemptAt  *time.Time `json:"next_attempt_at,omitempty"` // only set while pending
+	CompletedAt    *time.Time `json:"completed_at,omitempty"`
+	LastStatusCode *int       `json:"last_status_code,omitempty"`
+	LastError      string     `json:"last_error,omitempty"`
+}
+
+// A WebhookJob is a delivery that the worker has claimed and must attempt
+type WebhookJob struct {
+	DeliveryID int64
+	Attempt    int // counting from one
+	Webhook    *Webhook
+	Event      *TodoEvent
+}
+
+// A WebhookAttempt is the outcome of POSTing a delivery once
+type WebhookAttempt struct {
+	StatusCode *int // nil if no response was received
+	Error      string
+	Duration   time.Duration
+	Succeeded  bool
+	RetryAt    *time.Time // nil to give up on a failed delivery
+}
+
+func ValidateWebhook(v *validator.Validator, webhook *Webhook) {
+	v.Check(webhook.URL != "", "url", "must be provided")
+	v.Check(len(webhook.URL) <= 2000, "url", "must not be more than 2000 bytes long")
+	if webhook.URL != "" {
+		u, err := url.Parse(webhook.URL)
+		absolute := err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+		v.Check(absolute, "url", "must be an absolute http or https URL")
+	}
+	v.Check(webhook.EventTypes != nil, "event_types", "must be provided")
+	v.Check(validator.Unique(webhook.EventTypes), "event_types", "must not contain duplicate values")
+	for _, eventType := range webhook.EventTypes {
+		v.Check(validator.In(eventType, TodoEventTypes...), "event_types", "must only contain created, updated, deleted, restored or purged")
+	}
+}
+
+// The generateWebhookSecret() function creates the random secret that
+// deliveries are signed with
+func generateWebhookSecret() (string, error) {
+	randomBytes := make([]byte, 32)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(randomBytes), nil
+}
+
+// Define a WebhookModel which wraps a sql.DB connection pool
+type WebhookModel struct {
+	DB *sql.DB
+}
+
+// Insert() allows us to create a new Webhook with a fresh secret
+func (m WebhookModel) Insert(webhook *Webhook) error {
+	secret, err := generateWebhookSecret()
+	if err != nil {
+		return err
+	}
+	webhook.Secret = secret
+	query := `
+		INSERT INTO webhooks (user_id, url, secret, event_types)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, version
+	`
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	args := []interface{}{webhook.UserID, webhook.URL, webhook.Secret, pq.Array(webhook.EventTypes)}
+	err = m.DB.QueryRowContext(ctx, query, args...).Scan(&webhook.ID, &webhook.CreatedAt, &webhook.Version)
+	if err != nil {
+		return err
+	}
+	webhook.Active = true
+	return nil
+}
+
+// Get() allows us to retrieve a specific Webhook belonging to a User. The
+// secret is left out
+func (m WebhookModel) Get(id int64, userID int64) (*Webhook, error) {
+	// Ensure that there is a valid id
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+		SELECT id, created_at, user_id, url, event_types, failure_count, disabled_at, version
+		FROM webhooks
+		WHERE id = $1
+		AND user_id = $2
+	`
+	var webhook Webhook
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, id, userID).Scan(
+		&webhook.ID,
+		&webhook.CreatedAt,
+		&webhook.UserID,
+		&webhook.URL,
+		pq.Array(&webhook.EventTypes),
+		&webhook.FailureCount,
+		&webhook.DisabledAt,
+		&webhook.Version,
+	)
+	// Handle any errors
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	webhook.Active = webhook.DisabledAt == nil
+	return &webhook, nil
+}
+
+// GetAll() returns every Webhook belonging to a User, oldest first
+func (m WebhookModel) GetAll(userID int64) ([]*Webhook, error) {
+	query := `
+		SELECT id, created_at, user_id, url, event_types, failure_count, disabled_at, version
+		FROM webhooks
+		WHERE user_id = $1
+		ORDER BY id ASC
+	`
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	// Close the resultset
+	defer rows.Close()
+	webhooks := []*Webhook{}
+	for rows.Next() {
+		var webhook Webhook
+		err := rows.Scan(
+			&webhook.ID,
+			&webhook.CreatedAt,
+			&webhook.UserID,
+			&webhook.URL,
+			pq.Array(&webhook.EventTypes),
+			&webhook.FailureCount,
+			&webhook.DisabledAt,
+			&webhook.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+		webhook.Active = webhook.DisabledAt == nil
+		webhooks = append(webhooks, &webhook)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return webhooks, nil
+}
+
+// Update() allows us to change the URL, the event types and whether a
+// Webhook is active. Enabling a Webhook clears its failure count
+// Optimistic locking (version number)
+func (m WebhookModel) Update(webhook *Webhook) error {
+	query := `
+		UPDATE webhooks
+		SET url = $1, event_types = $2,
+			disabled_at = CASE WHEN $3 THEN NULL ELSE COALESCE(disabled_at, NOW()) END,
+			failure_count = CASE WHEN $3 AND disabled_at IS NOT NULL THEN 0 ELSE failure_count END,
+			version = version + 1
+		WHERE id = $4
+		AND user_id = $5
+		AND version = $6
+		RETURNING failure_count, disabled_at, version
+	`
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	args := []interface{}{webhook.URL, pq.Array(webhook.EventTypes), webhook.Active, webhook.ID, webhook.UserID, webhook.Version}
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&webhook.FailureCount, &webhook.DisabledAt, &webhook.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete() removes a Webhook along with its deliveries
+func (m WebhookModel) Delete(id int64, userID int64) error {
+	// Ensure that there is a valid id
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+	query := `
+		DELETE FROM webhooks
+		WHERE id = $1
+		AND user_id = $2
+	`
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// GetDeliveries() returns the deliveries for a Webhook belonging to a User,
+// newest first
+func (m WebhookModel) GetDeliveries(webhookID int64, userID int64, filters Filters) ([]*WebhookDelivery, Metadata, error) {
+	query := `
+		SELECT COUNT(*) OVER(), webhook_deliveries.id, webhook_deliveries.created_at, webhook_id, event_id,
+			todo_events.event_type, status, attempts, next_attempt_at, completed_at, last_attempt.status_code, last_attempt.error
+		FROM webhook_deliveries
+		INNER JOIN webhooks ON webhooks.id = webhook_deliveries.webhook_id
+		INNER JOIN todo_events ON todo_events.id = webhook_deliveries.event_id
+		LEFT JOIN LATERAL (
+			SELECT status_code, error FROM webhook_delivery_attempts
+			WHERE delivery_id = webhook_deliveries.id ORDER BY id DESC LIMIT 1
+		) AS last_attempt ON true
+		WHERE webhook_id = $1
+		AND webhooks.user_id = $2
+		ORDER BY webhook_deliveries.id DESC
+		LIMIT $3 OFFSET $4
+	`
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, webhookID, userID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	// Close the resultset
+	defer rows.Close()
+	totalRecords := 0
+	deliveries := []*WebhookDelivery{}
+	for rows.Next() {
+		var delivery WebhookDelivery
+		var lastError sql.NullString
+		err := rows.Scan(
+			&totalRecords,
+			&delivery.ID,
+			&delivery.CreatedAt,
+			&delivery.WebhookID,
+			&delivery.EventID,
+			&delivery.EventType,
+			&delivery.Status,
+			&delivery.Attempts,
+			&delivery.NextAttemptAt,
+			&delivery.CompletedAt,
+			&delivery.LastStatusCode,
+			&lastError,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		delivery.LastError = lastError.String
+		// The next attempt only means something while the delivery is pending
+		if delivery.Status != DeliveryPending {
+			delivery.NextAttemptAt = nil
+		}
+		deliveries = append(deliveries, &delivery)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return deliveries, metadata, nil
+}
+
+// ClaimDue() takes up to limit pending deliveries whose next attempt is
+// due and counts the attempt. Claimed deliveries are not due again until
+// the lease runs out, so a worker that dies part way through does not lose
+// them, and several servers can share the queue
+func (m WebhookModel) ClaimDue(limit int, lease time.Duration) ([]*WebhookJob, error) {
+	query := `
+		WITH claimed AS (
+			UPDATE webhook_deliveries
+			SET attempts = attempts + 1, next_attempt_at = NOW() + $2::double precision * INTERVAL '1 millisecond'
+			WHERE id IN (
+				SELECT webhook_deliveries.id
+				FROM webhook_deliveries
+				INNER JOIN webhooks ON webhooks.id = webhook_deliveries.webhook_id
+				WHERE status = 'pending'
+				AND next_attempt_at <= NOW()
+				AND webhooks.disabled_at IS NULL
+				ORDER BY next_attempt_at
+				LIMIT $1
+				FOR UPDATE OF webhook_deliveries SKIP LOCKED
+			)
+			RETURNING id, webhook_id, event_id, attempts
+		)
+		SELECT claimed.id, claimed.attempts, webhooks.id, webhooks.user_id, webhooks.url, webhooks.secret,
+			todo_events.id, todo_events.created_at, todo_events.todo_id, todo_events.event_type,
+			todo_events.old_values, todo_events.new_values, todo_events.version
+		FROM claimed
+		INNER JOIN webhooks ON webhooks.id = claimed.webhook_id
+		INNER JOIN todo_events ON todo_events.id = claimed.event_id
+		ORDER BY todo_events.id
+	`
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, limit, lease.Milliseconds())
+	if err != nil {
+		return nil, err
+	}
+	// Close the resultset
+	defer rows.Close()
+	jobs := []*WebhookJob{}
+	for rows.Next() {
+		job := WebhookJob{Webhook: &Webhook{}, Event: &TodoEvent{}}
+		var oldValues, newValues []byte
+		err := rows.Scan(
+			&job.DeliveryID,
+			&job.Attempt,
+			&job.Webhook.ID,
+			&job.Webhook.UserID,
+			&job.Webhook.URL,
+			&job.Webhook.Secret,
+			&job.Event.ID,
+			&job.Event.CreatedAt,
+			&job.Event.TodoID,
+			&job.Event.Type,
+			&oldValues,
+			&newValues,
+			&job.Event.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+		job.Event.UserID = job.Webhook.UserID
+		job.Event.OldValues = oldValues
+		job.Event.NewValues = newValues
+		jobs = append(jobs, &job)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// RecordAttempt() stores the outcome of an attempt and moves the delivery
+// on. A success resets the Webhook's failure count; a failure adds to it
+// and disables the Webhook once disableAfter attempts in a row have failed
+func (m WebhookModel) RecordAttempt(job *WebhookJob, attempt WebhookAttempt, disableAfter int) error {
+	// Create a context
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Cleanup to prevent memory leaks
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO webhook_delivery_attempts (delivery_id, status_code, error, duration_ms)
+		VALUES ($1, $2, $3, $4)
+	`, job.DeliveryID, attempt.StatusCode, attempt.Error, attempt.Duration.Milliseconds())
+	if err != nil {
+		return err
+	}
+	status := DeliveryPending
+	switch {
+	case attempt.Succeeded:
+		status = DeliveryDelivered
+	case attempt.RetryAt == nil:
+		status = DeliveryFailed
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE webhook_deliveries
+		SET status = $1,
+			next_attempt_at = COALESCE($2, next_attempt_at),
+			completed_at = CASE WHEN $1 = 'pending' THEN NULL ELSE NOW() END
+		WHERE id = $3
+	`, status, attempt.RetryAt, job.DeliveryID)
+	if err != nil {
+		return err
+	}
+	query := `
+		UPDATE webhooks
+		SET failure_count = 0
+		WHERE id = $1
+	`
+	args := []interface{}{job.Webhook.ID}
+	if !attempt.Succeeded {
+		// Disabling the Webhook is a change the owner did not make, so it
+		// bumps the version
+		query = `
+			UPDATE webhooks
+			SET failure_count = failure_count + 1,
+				disabled_at = CASE WHEN failure_count + 1 >= $2 THEN COALESCE(disabled_at, NOW()) ELSE disabled_at END,
+				version = CASE WHEN failure_count + 1 >= $2 AND disabled_at IS NULL THEN version + 1 ELSE version END
+			WHERE id = $1
+		`
+		args = append(args, disableAfter)
+	}
+	_, err = tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// The queueWebhookDeliveries() function queues a delivery of each event
+// for every active Webhook of the event's owner that wants that kind of
+// event. It runs in the transaction that wrote the events
+func queueWebhookDeliveries(ctx context.Context, tx *sql.Tx, eventIDs []int64) error {
+	query := `
+		INSERT INTO webhook_deliveries (webhook_id, event_id)
+		SELECT webhooks.id, todo_events.id
+		FROM todo_events
+		INNER JOIN webhooks ON webhooks.user_id = todo_events.user_id
+		WHERE todo_events.id = ANY($1)
+		AND webhooks.disabled_at IS NULL
+		AND (cardinality(webhooks.event_types) = 0 OR todo_events.event_type = ANY(webhooks.event_types))
+	`
+	_, err := tx.ExecContext(ctx, query, pq.Array(eventIDs))
+	return err
+}
@@ -0,0 +1,122 @@
+// Filename: internal/data/webhook_test.go
+
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+// The newTestDelivery() function registers a Webhook for a new User and
+// claims the delivery queued when one of their Tasks is created
+func newTestDelivery(t *testing.T, models Models, userID int64) *WebhookJob {
+	t.Helper()
+	webhook := &Webhook{UserID: userID, URL: "https://example.com/hook", EventTypes: []string{}}
+	err := models.Webhooks.Insert(webhook)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = models.Todos.Insert(&Todo{UserID: userID, Task: "Send a webhook"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobs, err := models.Webhooks.ClaimDue(10, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 1 || jobs[0].Webhook.ID != webhook.ID {
+		t.Fatalf("got %d jobs; want the one delivery for webhook %d", len(jobs), webhook.ID)
+	}
+	return jobs[0]
+}
+
+// The failedAttempt() function returns a failed attempt, with a retry
+// scheduled when retry is true
+func failedAttempt(retry bool) WebhookAttempt {
+	statusCode := 500
+	attempt := WebhookAttempt{StatusCode: &statusCode, Error: "the receiver responded with 500 Internal Server Error"}
+	if retry {
+		retryAt := time.Now().Add(time.Minute)
+		attempt.RetryAt = &retryAt
+	}
+	return attempt
+}
+
+func TestRecordAttemptDisablesAfterFailuresInARow(t *testing.T) {
+	db := newTestDB(t)
+	userID := newTestUser(t, db)
+	models := NewModels(db)
+	job := newTestDelivery(t, models, userID)
+
+	// A success in between starts the count again
+	statusCode := 200
+	for i, attempt := range []WebhookAttempt{
+		failedAttempt(true),
+		failedAttempt(true),
+		{StatusCode: &statusCode, Succeeded: true},
+		failedAttempt(true),
+		failedAttempt(true),
+	} {
+		err := models.Webhooks.RecordAttempt(job, attempt, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		webhook, err := models.Webhooks.Get(job.Webhook.ID, userID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !webhook.Active {
+			t.Fatalf("attempt %d: the webhook was disabled after %d failures in a row", i+1, webhook.FailureCount)
+		}
+	}
+	// The third failure in a row disables it
+	err := models.Webhooks.RecordAttempt(job, failedAttempt(true), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	webhook, err := models.Webhooks.Get(job.Webhook.ID, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if webhook.Active || webhook.FailureCount != 3 || webhook.DisabledAt == nil {
+		t.Errorf("got active %t with %d failures; want the webhook disabled after 3", webhook.Active, webhook.FailureCount)
+	}
+	if webhook.Version != 2 {
+		t.Errorf("got version %d; want disabling to bump it to 2", webhook.Version)
+	}
+}
+
+func TestRecordAttemptGivesUp(t *testing.T) {
+	db := newTestDB(t)
+	userID := newTestUser(t, db)
+	models := NewModels(db)
+	job := newTestDelivery(t, models, userID)
+
+	// Without a retry the delivery has failed for good
+	err := models.Webhooks.RecordAttempt(job, failedAttempt(false), 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deliveries, _, err := models.Webhooks.GetDeliveries(job.Webhook.ID, userID, Filters{Page: 1, PageSize: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 {
+		t.Fatalf("got %d deliveries; want 1", len(deliveries))
+	}
+	delivery := deliveries[0]
+	if delivery.Status != DeliveryFailed || delivery.CompletedAt == nil || delivery.NextAttemptAt != nil {
+		t.Errorf("got status %s; want %s with no next attempt", delivery.Status, DeliveryFailed)
+	}
+	if delivery.LastStatusCode == nil || *delivery.LastStatusCode != 500 || delivery.Attempts != 1 {
+		t.Errorf("got %d attempts with last status %v; want 1 attempt with status 500", delivery.Attempts, delivery.LastStatusCode)
+	}
+	// Nothing is left to claim
+	jobs, err := models.Webhooks.ClaimDue(10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 0 {
+		t.Errorf("got %d jobs; want the failed delivery to stay failed", len(jobs))
+	}
+}
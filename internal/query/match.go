@@ -0,0 +1,89 @@
+// Filename: internal/query/match.go
+
+package query
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Values maps the field names of a Schema onto the values of one record.
+// A missing or nil value behaves like NULL in the database
+type Values map[string]interface{}
+
+// Match() reports if a record matches a validated filter. It gives the
+// same answers as the SQL from Compile(), so a filter can be checked
+// against a record that is already in memory
+func Match(node Node, schema Schema, values Values) bool {
+	switch n := node.(type) {
+	case And:
+		return Match(n.Left, schema, values) && Match(n.Right, schema, values)
+	case Or:
+		return Match(n.Left, schema, values) || Match(n.Right, schema, values)
+	case Not:
+		return !Match(n.Expr, schema, values)
+	case Comparison:
+		return matchComparison(n, schema[n.Field], values[n.Field])
+	}
+	panic(fmt.Sprintf("unexpected filter node %T", node))
+}
+
+// The matchComparison() function tests a single value. As in the SQL,
+// a missing value never matches
+func matchComparison(n Comparison, field Field, actual interface{}) bool {
+	want, err := field.parse(n.Value)
+	if err != nil {
+		panic("unvalidated filter value: " + n.Value)
+	}
+	if actual == nil {
+		return false
+	}
+	var cmp int
+	switch field.Type {
+	case String:
+		if n.Op == ":" {
+			return strings.Contains(strings.ToLower(actual.(string)), strings.ToLower(want.(string)))
+		}
+		cmp = strings.Compare(actual.(string), want.(string))
+	case Enum:
+		cmp = strings.Compare(actual.(string), want.(string))
+	case Bool:
+		if actual.(bool) == want.(bool) {
+			cmp = 0
+		} else {
+			cmp = 1
+		}
+	case Int:
+		a, w := actual.(int64), want.(int64)
+		switch {
+		case a < w:
+			cmp = -1
+		case a > w:
+			cmp = 1
+		}
+	case Time:
+		a, w := actual.(time.Time), want.(time.Time)
+		switch {
+		case a.Before(w):
+			cmp = -1
+		case a.After(w):
+			cmp = 1
+		}
+	}
+	switch n.Op {
+	case ":", "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return false
+}
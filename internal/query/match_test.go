@@ -0,0 +1,135 @@
+// Filename: internal/query/match_test.go
+
+package query
+
+import (
+	"database/sql"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+// testRows are the records the matcher and the database are compared on.
+// Missing values are left out of the map, as they are NULL in testRowsSQL
+var testRows = []Values{
+	{"id": int64(1), "task": "Write report", "status": "todo", "complete": false, "created": day(2026, 10, 1), "due": day(2026, 10, 15)},
+	{"id": int64(2), "task": "50% done_now", "status": "done", "complete": true, "created": day(2026, 11, 1)},
+	{"id": int64(3), "task": "500 done", "status": "todo", "complete": false, "created": day(2026, 12, 1), "due": day(2026, 11, 1)},
+	{"id": int64(4), "task": "REPORT draft"},
+	{"id": int64(5), "status": "done", "complete": true, "created": day(2026, 11, 1).Add(12 * time.Hour), "due": day(2026, 12, 24)},
+	{"id": int64(6), "task": `back\slash`, "status": "todo", "complete": false, "created": day(2026, 9, 1)},
+}
+
+// testRowsSQL holds the same records as testRows
+const testRowsSQL = `
+	SELECT * FROM (VALUES
+		(1::bigint, 'Write report'::text, 'todo'::text, '2026-10-01T00:00:00Z'::timestamptz, '2026-10-15T00:00:00Z'::timestamptz),
+		(2, '50% done_now', 'done', '2026-11-01T00:00:00Z', NULL),
+		(3, '500 done', 'todo', '2026-12-01T00:00:00Z', '2026-11-01T00:00:00Z'),
+		(4, 'REPORT draft', NULL, NULL, NULL),
+		(5, NULL, 'done', '2026-11-01T12:00:00Z', '2026-12-24T00:00:00Z'),
+		(6, 'back\slash', 'todo', '2026-09-01T00:00:00Z', NULL)
+	) AS todos (id, task, status, created_at, due_at)
+`
+
+// The day() function returns midnight UTC on a date
+func day(year int, month time.Month, d int) time.Time {
+	return time.Date(year, month, d, 0, 0, 0, 0, time.UTC)
+}
+
+// matchTests are filters along with the ids of the testRows they match.
+// Every operator is covered for every type, along with missing values
+var matchTests = []struct {
+	filter string
+	ids    []int64
+}{
+	{`task:report`, []int64{1, 4}},
+	{`task:"50%"`, []int64{2}},
+	{`task:"_"`, []int64{2}},
+	{`task:"\\"`, []int64{6}},
+	{`task="Write report"`, []int64{1}},
+	{`task="write report"`, []int64{}},
+	{`task!="Write report"`, []int64{2, 3, 4, 6}},
+	{`NOT task="Write report"`, []int64{2, 3, 4, 5, 6}},
+	{`id:3`, []int64{3}},
+	{`id=3`, []int64{3}},
+	{`id!=3`, []int64{1, 2, 4, 5, 6}},
+	{`id<3`, []int64{1, 2}},
+	{`id<=3`, []int64{1, 2, 3}},
+	{`id>3`, []int64{4, 5, 6}},
+	{`id>=3`, []int64{3, 4, 5, 6}},
+	{`complete:yes`, []int64{2, 5}},
+	{`complete=no`, []int64{1, 3, 6}},
+	{`complete!=yes`, []int64{1, 3, 6}},
+	{`NOT complete:yes`, []int64{1, 3, 4, 6}},
+	{`status:TODO`, []int64{1, 3, 6}},
+	{`status=done`, []int64{2, 5}},
+	{`status!=todo`, []int64{2, 5}},
+	{`created:2026-11-01`, []int64{2}},
+	{`created!=2026-11-01`, []int64{1, 3, 5, 6}},
+	{`created<2026-11-01`, []int64{1, 6}},
+	{`created<=2026-11-01`, []int64{1, 2, 6}},
+	{`created>2026-11-01`, []int64{3, 5}},
+	{`created>=2026-11-01T12:00:00Z`, []int64{3, 5}},
+	{`due<=2026-11-01`, []int64{1, 3}},
+	{`due>2026-10-15`, []int64{3, 5}},
+	{`NOT due<2026-11-01`, []int64{2, 3, 4, 5, 6}},
+	{`NOT (task:report OR due<2026-11-01)`, []int64{2, 3, 5, 6}},
+	{`task:report OR complete:yes AND due>2026-12-01`, []int64{1, 4, 5}},
+	{`complete:NO AND (task:report OR created<2026-11-01) AND NOT task:"draft" AND id>0`, []int64{1, 6}},
+}
+
+func TestMatch(t *testing.T) {
+	for _, tt := range matchTests {
+		node := mustParse(t, tt.filter)
+		got := []int64{}
+		for _, row := range testRows {
+			if Match(node, testSchema, row) {
+				got = append(got, row["id"].(int64))
+			}
+		}
+		if !reflect.DeepEqual(got, tt.ids) {
+			t.Errorf("%s: matched %v; want %v", tt.filter, got, tt.ids)
+		}
+	}
+}
+
+// The SQL from Compile() has to select the same rows that Match() accepts.
+// This needs a database, and is skipped unless TODO_TEST_DB_DSN is set
+func TestCompileAgreesWithMatch(t *testing.T) {
+	dsn := os.Getenv("TODO_TEST_DB_DSN")
+	if dsn == "" {
+		t.Skip("TODO_TEST_DB_DSN is not set")
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, tt := range matchTests {
+		condition, args := Compile(mustParse(t, tt.filter), testSchema, 1)
+		rows, err := db.Query(`SELECT id FROM (`+testRowsSQL+`) AS todos WHERE `+condition+` ORDER BY id`, args...)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.filter, err)
+		}
+		got := []int64{}
+		for rows.Next() {
+			var id int64
+			err := rows.Scan(&id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, id)
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, tt.ids) {
+			t.Errorf("%s: the database selected %v; want %v", tt.filter, got, tt.ids)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+// Filename: internal/query/query.go
+
+package query
+
+import (
+	"fmt"
+	"strings"
+)
+
+// maxDepth bounds how deeply parentheses and NOTs may be nested
+const maxDepth = 20
+
+// The comparison operators, longest first so that "<=" is read before "<"
+var operators = []string{"!=", "<=", ">=", ":", "=", "<", ">"}
+
+// A Node is one part of a parsed filter expression
+type Node interface {
+	node()
+}
+
+// And matches when both sides match
+type And struct {
+	Left  Node
+	Right Node
+}
+
+// Or matches when either side matches
+type Or struct {
+	Left  Node
+	Right Node
+}
+
+// Not matches when the expression does not
+type Not struct {
+	Expr Node
+}
+
+// Comparison tests a single field, for example task:report or id>100
+type Comparison struct {
+	Field string
+	Op    string
+	Value string
+	Pos   int // where the comparison starts in the input, counting from one
+}
+
+func (And) node()        {}
+func (Or) node()         {}
+func (Not) node()        {}
+func (Comparison) node() {}
+
+// A SyntaxError reports where the parser gave up
+type SyntaxError struct {
+	Pos     int
+	Message string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%s at position %d", e.Message, e.Pos)
+}
+
+// Parse() reads an expression such as
+//
+//	complete:NO AND (task:report OR created<2026-11-01) AND NOT task:"draft"
+//
+// AND binds more tightly than OR, and the keywords are case-insensitive.
+// Values containing spaces or parentheses must be quoted
+func Parse(input string) (Node, error) {
+	p := &parser{input: input}
+	node, err := p.parseOr(0)
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.input) {
+		return nil, p.errorf("unexpected %q", p.rest())
+	}
+	return node, nil
+}
+
+type parser struct {
+	input string
+	pos   int
+}
+
+func (p *parser) parseOr(depth int) (Node, error) {
+	left, err := p.parseAnd(depth)
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("OR") {
+		right, err := p.parseAnd(depth)
+		if err != nil {
+			return nil, err
+		}
+		left = Or{Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd(depth int) (Node, error) {
+	left, err := p.parseUnary(depth)
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("AND") {
+		right, err := p.parseUnary(depth)
+		if err != nil {
+			return nil, err
+		}
+		left = And{Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary(depth int) (Node, error) {
+	if depth > maxDepth {
+		return nil, p.errorf("expression is nested more than %d levels deep", maxDepth)
+	}
+	if p.keyword("NOT") {
+		expr, err := p.parseUnary(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		return Not{Expr: expr}, nil
+	}
+	p.skipSpace()
+	if p.peek() == '(' {
+		p.pos++
+		expr, err := p.parseOr(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		if p.peek() != ')' {
+			return nil, p.errorf("expected )")
+		}
+		p.pos++
+		return expr, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (Node, error) {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.input) && isFieldChar(p.input[p.pos], p.pos == start) {
+		p.pos++
+	}
+	if p.pos == start {
+		if p.pos == len(p.input) {
+			return nil, p.errorf("expected a field name but the filter ended")
+		}
+		return nil, p.errorf("expected a field name")
+	}
+	comparison := Comparison{Field: strings.ToLower(p.input[start:p.pos]), Pos: start + 1}
+	for _, op := range operators {
+		if strings.HasPrefix(p.input[p.pos:], op) {
+			comparison.Op = op
+			p.pos += len(op)
+			break
+		}
+	}
+	if comparison.Op == "" {
+		return nil, p.errorf("expected one of %s after %s", strings.Join(operators, " "), comparison.Field)
+	}
+	value, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+	comparison.Value = value
+	return comparison, nil
+}
+
+// The parseValue() method reads a quoted string, where \" and \\ are
+// escapes, or a bare word that runs until a space or parenthesis
+func (p *parser) parseValue() (string, error) {
+	if p.peek() == '"' {
+		p.pos++
+		var value strings.Builder
+		for p.pos < len(p.input) {
+			c := p.input[p.pos]
+			switch {
+			case c == '"':
+				p.pos++
+				return value.String(), nil
+			case c == '\\' && p.pos+1 < len(p.input):
+				value.WriteByte(p.input[p.pos+1])
+				p.pos += 2
+			default:
+				value.WriteByte(c)
+				p.pos++
+			}
+		}
+		return "", p.errorf("unterminated quoted value")
+	}
+	start := p.pos
+	for p.pos < len(p.input) && !strings.ContainsRune(" \t\r\n()\"", rune(p.input[p.pos])) {
+		p.pos++
+	}
+	if p.pos == start {
+		return "", p.errorf("expected a value")
+	}
+	return p.input[start:p.pos], nil
+}
+
+// The keyword() method consumes a keyword if it comes next and is followed
+// by a space, a parenthesis or the end of the input
+func (p *parser) keyword(word string) bool {
+	p.skipSpace()
+	end := p.pos + len(word)
+	if end > len(p.input) || !strings.EqualFold(p.input[p.pos:end], word) {
+		return false
+	}
+	if end < len(p.input) && !strings.ContainsRune(" \t\r\n()", rune(p.input[end])) {
+		return false
+	}
+	p.pos = end
+	return true
+}
+
+func (p *parser) skipSpace() {
+	for p.pos < len(p.input) && strings.ContainsRune(" \t\r\n", rune(p.input[p.pos])) {
+		p.pos++
+	}
+}
+
+func (p *parser) peek() byte {
+	if p.pos < len(p.input) {
+		return p.input[p.pos]
+	}
+	return 0
+}
+
+// The rest() method returns a short piece of the unread input for errors
+func (p *parser) rest() string {
+	rest := p.input[p.pos:]
+	if len(rest) > 20 {
+		rest = rest[:20] + "..."
+	}
+	return rest
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return &SyntaxError{Pos: p.pos + 1, Message: fmt.Sprintf(format, args...)}
+}
+
+func isFieldChar(c byte, first bool) bool {
+	switch {
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_':
+		return true
+	case c >= '0' && c <= '9':
+		return !first
+	}
+	return false
+}
@@ -0,0 +1,129 @@
+// Filename: internal/query/query_test.go
+
+package query
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseExample(t *testing.T) {
+	input := `complete:NO AND (task:report OR created<2026-11-01) AND NOT task:"draft" AND id>100`
+	got, err := Parse(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// AND binds more tightly than OR and groups to the left
+	want := And{
+		Left: And{
+			Left: And{
+				Left: Comparison{Field: "complete", Op: ":", Value: "NO", Pos: 1},
+				Right: Or{
+					Left:  Comparison{Field: "task", Op: ":", Value: "report", Pos: 18},
+					Right: Comparison{Field: "created", Op: "<", Value: "2026-11-01", Pos: 33},
+				},
+			},
+			Right: Not{Expr: Comparison{Field: "task", Op: ":", Value: "draft", Pos: 61}},
+		},
+		Right: Comparison{Field: "id", Op: ">", Value: "100", Pos: 78},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v\nwant %#v", got, want)
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input string
+		want  Node
+	}{
+		{
+			input: "a:1 OR b:2 AND c:3",
+			want: Or{
+				Left:  Comparison{Field: "a", Op: ":", Value: "1", Pos: 1},
+				Right: And{Left: Comparison{Field: "b", Op: ":", Value: "2", Pos: 8}, Right: Comparison{Field: "c", Op: ":", Value: "3", Pos: 16}},
+			},
+		},
+		{
+			input: "(a:1 or b:2) and not c:3",
+			want: And{
+				Left:  Or{Left: Comparison{Field: "a", Op: ":", Value: "1", Pos: 2}, Right: Comparison{Field: "b", Op: ":", Value: "2", Pos: 9}},
+				Right: Not{Expr: Comparison{Field: "c", Op: ":", Value: "3", Pos: 22}},
+			},
+		},
+		{
+			input: "Task=x",
+			want:  Comparison{Field: "task", Op: "=", Value: "x", Pos: 1},
+		},
+		{
+			input: "a!=1",
+			want:  Comparison{Field: "a", Op: "!=", Value: "1", Pos: 1},
+		},
+		{
+			input: "a<=1",
+			want:  Comparison{Field: "a", Op: "<=", Value: "1", Pos: 1},
+		},
+		{
+			input: "a>=1",
+			want:  Comparison{Field: "a", Op: ">=", Value: "1", Pos: 1},
+		},
+		{
+			input: `task:"a (quoted) \"value\" \\ here"`,
+			want:  Comparison{Field: "task", Op: ":", Value: `a (quoted) "value" \ here`, Pos: 1},
+		},
+		{
+			// A field may be named like a keyword as long as it is not
+			// followed by a space
+			input: "order:1",
+			want:  Comparison{Field: "order", Op: ":", Value: "1", Pos: 1},
+		},
+		{
+			input: "  a:1  ",
+			want:  Comparison{Field: "a", Op: ":", Value: "1", Pos: 3},
+		},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.input)
+		if err != nil {
+			t.Errorf("Parse(%q) returned error %v", tt.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Parse(%q) = %#v; want %#v", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestParseSyntaxErrors(t *testing.T) {
+	tests := []struct {
+		input   string
+		pos     int
+		message string
+	}{
+		{"", 1, "expected a field name but the filter ended"},
+		{"task:a AND", 11, "expected a field name but the filter ended"},
+		{"1task:a", 1, "expected a field name"},
+		{"task", 5, "expected one of != <= >= : = < > after task"},
+		{"task:", 6, "expected a value"},
+		{"task:)", 6, "expected a value"},
+		{"(task:a", 8, "expected )"},
+		{"task:a)", 7, `unexpected ")"`},
+		{"task:a task:b", 8, `unexpected "task:b"`},
+		{`task:"abc`, 10, "unterminated quoted value"},
+		{strings.Repeat("NOT ", 22) + "a:1", 84, "expression is nested more than 20 levels deep"},
+		{strings.Repeat("(", 22) + "a:1" + strings.Repeat(")", 22), 22, "expression is nested more than 20 levels deep"},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.input)
+		var syntaxError *SyntaxError
+		if !errors.As(err, &syntaxError) {
+			t.Errorf("Parse(%q) returned %v; want a SyntaxError", tt.input, err)
+			continue
+		}
+		if syntaxError.Pos != tt.pos || syntaxError.Message != tt.message {
+			t.Errorf("Parse(%q) = %q at %d; want %q at %d", tt.input, syntaxError.Message, syntaxError.Pos, tt.message, tt.pos)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+// Filename: internal/query/sql.go
+
+package query
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"AWD_Quiz3.ryanarmstrong.net/internal/validator"
+)
+
+// maxComparisons limits how much work a single filter can ask for
+const maxComparisons = 20
+
+// The kinds of value a Field holds
+type Type int
+
+const (
+	String Type = iota // ":" matches a substring, "=" the whole value
+	Int
+	Bool // YES, NO, true or false
+	Time // a date such as 2026-11-01 or an RFC 3339 time
+	Enum // one of Field.Values
+)
+
+// A Field describes something that a filter may compare. Column is the SQL
+// expression that is compared; it comes from the code, never the client
+type Field struct {
+	Column string
+	Type   Type
+	Values []string // the allowed values of an Enum
+}
+
+// A Schema maps the field names a client may use onto Fields
+type Schema map[string]Field
+
+// The operators each type of field accepts
+var typeOperators = map[Type][]string{
+	String: {":", "=", "!="},
+	Int:    {":", "=", "!=", "<", "<=", ">", ">="},
+	Bool:   {":", "=", "!="},
+	Time:   {":", "=", "!=", "<", "<=", ">", ">="},
+	Enum:   {":", "=", "!="},
+}
+
+// Validate() checks that every comparison in a parsed filter uses a known
+// field, an operator that suits it and a value of the right type. Errors
+// are added to v under key
+func Validate(v *validator.Validator, key string, node Node, schema Schema) {
+	comparisons := 0
+	walk(node, func(c Comparison) {
+		comparisons++
+		field, ok := schema[c.Field]
+		if !ok {
+			v.AddError(key, fmt.Sprintf("unknown field %q at position %d, use one of %s", c.Field, c.Pos, strings.Join(schema.names(), ", ")))
+			return
+		}
+		v.Check(validator.In(c.Op, typeOperators[field.Type]...), key,
+			fmt.Sprintf("%s does not support %s at position %d, use one of %s", c.Field, c.Op, c.Pos, strings.Join(typeOperators[field.Type], " ")))
+		_, err := field.parse(c.Value)
+		if err != nil {
+			v.AddError(key, fmt.Sprintf("%s %s at position %d", c.Field, err, c.Pos))
+		}
+	})
+	v.Check(comparisons <= maxComparisons, key, fmt.Sprintf("must not contain more than %d comparisons", maxComparisons))
+}
+
+// Compile() turns a validated filter into a SQL condition. Values are
+// passed as parameters numbered from next, and are returned in order
+func Compile(node Node, schema Schema, next int) (string, []interface{}) {
+	c := &compiler{schema: schema, next: next}
+	return c.compile(node), c.args
+}
+
+type compiler struct {
+	schema Schema
+	next   int
+	args   []interface{}
+}
+
+func (c *compiler) compile(node Node) string {
+	switch n := node.(type) {
+	case And:
+		return "(" + c.compile(n.Left) + " AND " + c.compile(n.Right) + ")"
+	case Or:
+		return "(" + c.compile(n.Left) + " OR " + c.compile(n.Right) + ")"
+	case Not:
+		return "NOT " + c.compile(n.Expr)
+	case Comparison:
+		return c.comparison(n)
+	}
+	panic(fmt.Sprintf("unexpected filter node %T", node))
+}
+
+// The comparison() method writes a single test. A missing value never
+// matches, so NULLs are folded to false and NOT behaves as expected
+func (c *compiler) comparison(n Comparison) string {
+	field := c.schema[n.Field]
+	value, err := field.parse(n.Value)
+	if err != nil {
+		panic("unvalidated filter value: " + n.Value)
+	}
+	op := n.Op
+	switch {
+	case op == ":" && field.Type == String:
+		op = "ILIKE"
+		value = "%" + escapeLike(value.(string)) + "%"
+	case op == ":":
+		op = "="
+	case op == "!=":
+		op = "<>"
+	}
+	c.args = append(c.args, value)
+	placeholder := "$" + strconv.Itoa(c.next)
+	c.next++
+	return fmt.Sprintf("COALESCE(%s %s %s, false)", field.Column, op, placeholder)
+}
+
+// The parse() method converts a value from a filter into the Go type that
+// is sent to the database
+func (field Field) parse(value string) (interface{}, error) {
+	switch field.Type {
+	case Int:
+		i, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("must be compared with a whole number")
+		}
+		return i, nil
+	case Bool:
+		switch strings.ToLower(value) {
+		case "yes", "true":
+			return true, nil
+		case "no", "false":
+			return false, nil
+		}
+		return nil, fmt.Errorf("must be compared with YES or NO")
+	case Time:
+		if t, err := time.Parse(time.RFC3339, value); err == nil {
+			return t, nil
+		}
+		if t, err := time.Parse("2006-01-02", value); err == nil {
+			return t, nil
+		}
+		return nil, fmt.Errorf("must be compared with a date in the form YYYY-MM-DD or an RFC 3339 time")
+	case Enum:
+		value = strings.ToLower(value)
+		if !validator.In(value, field.Values...) {
+			return nil, fmt.Errorf("must be compared with one of %s", strings.Join(field.Values, ", "))
+		}
+		return value, nil
+	}
+	return value, nil
+}
+
+// The names() method lists the fields in a schema in alphabetical order
+func (schema Schema) names() []string {
+	names := make([]string, 0, len(schema))
+	for name := range schema {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// The walk() function calls fn for every comparison in a filter
+func walk(node Node, fn func(Comparison)) {
+	switch n := node.(type) {
+	case And:
+		walk(n.Left, fn)
+		walk(n.Right, fn)
+	case Or:
+		walk(n.Left, fn)
+		walk(n.Right, fn)
+	case Not:
+		walk(n.Expr, fn)
+	case Comparison:
+		fn(n)
+	}
+}
+
+// The escapeLike() function stops a substring search from treating % and _
+// as wildcards
+func escapeLike(value string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return replacer.Replace(value)
+}
@@ -0,0 +1,124 @@
+// Filename: internal/query/sql_test.go
+
+package query
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"AWD_Quiz3.ryanarmstrong.net/internal/validator"
+)
+
+// testSchema has a field of every type
+var testSchema = Schema{
+	"id":       {Column: "id", Type: Int},
+	"task":     {Column: "task", Type: String},
+	"complete": {Column: "(status = 'done')", Type: Bool},
+	"status":   {Column: "status", Type: Enum, Values: []string{"todo", "done"}},
+	"created":  {Column: "created_at", Type: Time},
+	"due":      {Column: "due_at", Type: Time},
+}
+
+// The mustParse() function parses and validates a filter that the test
+// expects to be valid
+func mustParse(t *testing.T, input string) Node {
+	t.Helper()
+	node, err := Parse(input)
+	if err != nil {
+		t.Fatalf("Parse(%q) returned error %v", input, err)
+	}
+	v := validator.New()
+	Validate(v, "filter", node, testSchema)
+	if !v.Valid() {
+		t.Fatalf("Validate(%q) failed: %v", input, v.Errors)
+	}
+	return node
+}
+
+func TestCompileExample(t *testing.T) {
+	node := mustParse(t, `complete:NO AND (task:report OR created<2026-11-01) AND NOT task:"draft" AND id>100`)
+	sql, args := Compile(node, testSchema, 3)
+	want := "(((COALESCE((status = 'done') = $3, false) AND (COALESCE(task ILIKE $4, false) OR COALESCE(created_at < $5, false))) AND NOT COALESCE(task ILIKE $6, false)) AND COALESCE(id > $7, false))"
+	if sql != want {
+		t.Errorf("got SQL\n%s\nwant\n%s", sql, want)
+	}
+	wantArgs := []interface{}{false, "%report%", time.Date(2026, time.November, 1, 0, 0, 0, 0, time.UTC), "%draft%", int64(100)}
+	if !reflect.DeepEqual(args, wantArgs) {
+		t.Errorf("got args %#v; want %#v", args, wantArgs)
+	}
+}
+
+func TestCompileOperators(t *testing.T) {
+	tests := []struct {
+		filter string
+		sql    string
+		arg    interface{}
+	}{
+		{`task:report`, "COALESCE(task ILIKE $1, false)", "%report%"},
+		{`task:"50%_\\"`, "COALESCE(task ILIKE $1, false)", `%50\%\_\\%`},
+		{`task=report`, "COALESCE(task = $1, false)", "report"},
+		{`task!=report`, "COALESCE(task <> $1, false)", "report"},
+		{`id:5`, "COALESCE(id = $1, false)", int64(5)},
+		{`id=5`, "COALESCE(id = $1, false)", int64(5)},
+		{`id!=5`, "COALESCE(id <> $1, false)", int64(5)},
+		{`id<5`, "COALESCE(id < $1, false)", int64(5)},
+		{`id<=5`, "COALESCE(id <= $1, false)", int64(5)},
+		{`id>5`, "COALESCE(id > $1, false)", int64(5)},
+		{`id>=-5`, "COALESCE(id >= $1, false)", int64(-5)},
+		{`complete:yes`, "COALESCE((status = 'done') = $1, false)", true},
+		{`complete!=FALSE`, "COALESCE((status = 'done') <> $1, false)", false},
+		{`status:DONE`, "COALESCE(status = $1, false)", "done"},
+		{`due>=2026-01-02T03:04:05Z`, "COALESCE(due_at >= $1, false)", time.Date(2026, time.January, 2, 3, 4, 5, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		sql, args := Compile(mustParse(t, tt.filter), testSchema, 1)
+		if sql != tt.sql {
+			t.Errorf("%s: got SQL %s; want %s", tt.filter, sql, tt.sql)
+		}
+		if len(args) != 1 || !reflect.DeepEqual(args[0], tt.arg) {
+			t.Errorf("%s: got args %#v; want [%#v]", tt.filter, args, tt.arg)
+		}
+	}
+}
+
+// Values from the client only ever reach the database as parameters
+func TestCompileKeepsValuesOutOfSQL(t *testing.T) {
+	node := mustParse(t, `task:"'; DROP TABLE todos; --" OR task="x') OR true --"`)
+	sql, args := Compile(node, testSchema, 1)
+	if strings.Contains(sql, "DROP") || strings.Contains(sql, "true --") {
+		t.Errorf("a value was written into the SQL: %s", sql)
+	}
+	if len(args) != 2 {
+		t.Errorf("got %d args; want 2", len(args))
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		filter  string
+		message string
+	}{
+		{`nope:1`, `unknown field "nope" at position 1, use one of complete, created, due, id, status, task`},
+		{`id:1 AND task<x`, "task does not support < at position 10, use one of : = !="},
+		{`complete>yes`, "complete does not support > at position 1, use one of : = !="},
+		{`status<=done`, "status does not support <= at position 1, use one of : = !="},
+		{`id:abc`, "id must be compared with a whole number at position 1"},
+		{`complete:maybe`, "complete must be compared with YES or NO at position 1"},
+		{`due:tomorrow`, "due must be compared with a date in the form YYYY-MM-DD or an RFC 3339 time at position 1"},
+		{`status:open`, "status must be compared with one of todo, done at position 1"},
+		{strings.Repeat("id:1 AND ", 20) + "id:1", "must not contain more than 20 comparisons"},
+	}
+	for _, tt := range tests {
+		node, err := Parse(tt.filter)
+		if err != nil {
+			t.Fatalf("Parse(%q) returned error %v", tt.filter, err)
+		}
+		v := validator.New()
+		Validate(v, "filter", node, testSchema)
+		if got := v.Errors["filter"]; got != tt.message {
+			t.Errorf("Validate(%q) = %q; want %q", tt.filter, got, tt.message)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+// Filename: internal/recurrence/recurrence.go
+
+package recurrence
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The frequencies we support
+const (
+	FreqDaily   = "DAILY"
+	FreqWeekly  = "WEEKLY"
+	FreqMonthly = "MONTHLY"
+	FreqYearly  = "YEARLY"
+)
+
+// maxSearchDays bounds how far ahead Next() looks for an occurrence
+const maxSearchDays = 366 * 20
+
+var weekdays = map[string]time.Weekday{
+	"SU": time.Sunday,
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+}
+
+// Rule is a parsed subset of an iCalendar RRULE
+type Rule struct {
+	Freq       string
+	Interval   int
+	ByDay      []time.Weekday
+	ByMonthDay []int
+	Count      int // zero means no limit
+	Until      *time.Time
+}
+
+// Parse() reads a rule such as "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,WE".
+// Only FREQ, INTERVAL, BYDAY, BYMONTHDAY, COUNT and UNTIL are supported
+func Parse(value string) (*Rule, error) {
+	value = strings.TrimPrefix(strings.TrimSpace(value), "RRULE:")
+	if value == "" {
+		return nil, errors.New("must not be empty")
+	}
+	rule := &Rule{Interval: 1}
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(value, ";") {
+		key, val, found := strings.Cut(part, "=")
+		key = strings.ToUpper(key)
+		if !found || val == "" {
+			return nil, fmt.Errorf("part %q must be in the form KEY=VALUE", part)
+		}
+		if seen[key] {
+			return nil, fmt.Errorf("%s must only appear once", key)
+		}
+		seen[key] = true
+		switch key {
+		case "FREQ":
+			rule.Freq = strings.ToUpper(val)
+			switch rule.Freq {
+			case FreqDaily, FreqWeekly, FreqMonthly, FreqYearly:
+			default:
+				return nil, errors.New("FREQ must be DAILY, WEEKLY, MONTHLY or YEARLY")
+			}
+		case "INTERVAL":
+			interval, err := strconv.Atoi(val)
+			if err != nil || interval < 1 {
+				return nil, errors.New("INTERVAL must be a positive integer")
+			}
+			rule.Interval = interval
+		case "BYDAY":
+			for _, day := range strings.Split(val, ",") {
+				weekday, ok := weekdays[strings.ToUpper(day)]
+				if !ok {
+					return nil, fmt.Errorf("BYDAY value %q must be one of MO, TU, WE, TH, FR, SA or SU", day)
+				}
+				rule.ByDay = append(rule.ByDay, weekday)
+			}
+		case "BYMONTHDAY":
+			for _, day := range strings.Split(val, ",") {
+				monthDay, err := strconv.Atoi(day)
+				if err != nil || monthDay == 0 || monthDay < -31 || monthDay > 31 {
+					return nil, fmt.Errorf("BYMONTHDAY value %q must be between 1 and 31 or -31 and -1", day)
+				}
+				rule.ByMonthDay = append(rule.ByMonthDay, monthDay)
+			}
+		case "COUNT":
+			count, err := strconv.Atoi(val)
+			if err != nil || count < 1 {
+				return nil, errors.New("COUNT must be a positive integer")
+			}
+			rule.Count = count
+		case "UNTIL":
+			until, err := parseUntil(val)
+			if err != nil {
+				return nil, err
+			}
+			rule.Until = &until
+		default:
+			return nil, fmt.Errorf("%s is not supported", key)
+		}
+	}
+	if rule.Freq == "" {
+		return nil, errors.New("FREQ must be provided")
+	}
+	if rule.Count > 0 && rule.Until != nil {
+		return nil, errors.New("COUNT and UNTIL must not both be provided")
+	}
+	return rule, nil
+}
+
+// The parseUntil() function accepts the UTC date-time and date forms of UNTIL
+func parseUntil(value string) (time.Time, error) {
+	if t, err := time.Parse("20060102T150405Z", value); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse("20060102", value); err == nil {
+		// A date includes the whole day
+		return t.Add(24*time.Hour - time.Second), nil
+	}
+	return time.Time{}, errors.New("UNTIL must be in the form YYYYMMDD or YYYYMMDDTHHMMSSZ")
+}
+
+// Next() returns the occurrence that follows anchor, which is occurrence
+// number n (counting from one) of the series. The boolean is false once
+// the series has ended
+func (rule *Rule) Next(anchor time.Time, n int) (time.Time, bool) {
+	if rule.Count > 0 && n >= rule.Count {
+		return time.Time{}, false
+	}
+	for i := 1; i <= maxSearchDays; i++ {
+		candidate := anchor.AddDate(0, 0, i)
+		if rule.Until != nil && candidate.After(*rule.Until) {
+			return time.Time{}, false
+		}
+		if rule.matches(anchor, candidate) {
+			return candidate, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Occurrences() returns up to limit occurrences that follow anchor
+func (rule *Rule) Occurrences(anchor time.Time, n int, limit int) []time.Time {
+	occurrences := []time.Time{}
+	for len(occurrences) < limit {
+		next, ok := rule.Next(anchor, n)
+		if !ok {
+			break
+		}
+		occurrences = append(occurrences, next)
+		anchor = next
+		n++
+	}
+	return occurrences
+}
+
+// The matches() method checks if a day falls on the rule when the series
+// is counted from anchor
+func (rule *Rule) matches(anchor time.Time, t time.Time) bool {
+	// Only every INTERVAL-th period is eligible
+	var period int
+	switch rule.Freq {
+	case FreqDaily:
+		period = daysBetween(anchor, t)
+	case FreqWeekly:
+		period = daysBetween(startOfWeek(anchor), startOfWeek(t)) / 7
+	case FreqMonthly:
+		period = (t.Year()-anchor.Year())*12 + int(t.Month()) - int(anchor.Month())
+	case FreqYearly:
+		period = t.Year() - anchor.Year()
+	}
+	if period%rule.Interval != 0 {
+		return false
+	}
+	// Explicit BYxxx parts narrow the days within a period
+	if len(rule.ByDay) > 0 || len(rule.ByMonthDay) > 0 {
+		return rule.matchesByDay(t) && rule.matchesByMonthDay(t)
+	}
+	// Otherwise the day is taken from the anchor
+	switch rule.Freq {
+	case FreqWeekly:
+		return t.Weekday() == anchor.Weekday()
+	case FreqMonthly:
+		return t.Day() == anchor.Day()
+	case FreqYearly:
+		return t.Month() == anchor.Month() && t.Day() == anchor.Day()
+	}
+	return true
+}
+
+func (rule *Rule) matchesByDay(t time.Time) bool {
+	if len(rule.ByDay) == 0 {
+		return true
+	}
+	for _, weekday := range rule.ByDay {
+		if t.Weekday() == weekday {
+			return true
+		}
+	}
+	return false
+}
+
+func (rule *Rule) matchesByMonthDay(t time.Time) bool {
+	if len(rule.ByMonthDay) == 0 {
+		return true
+	}
+	// Negative days count back from the end of the month
+	daysInMonth := time.Date(t.Year(), t.Month()+1, 0, 0, 0, 0, 0, t.Location()).Day()
+	for _, monthDay := range rule.ByMonthDay {
+		if monthDay == t.Day() || daysInMonth+monthDay+1 == t.Day() {
+			return true
+		}
+	}
+	return false
+}
+
+// The daysBetween() function counts calendar days from a to b
+func daysBetween(a time.Time, b time.Time) int {
+	dateA := time.Date(a.Year(), a.Month(), a.Day(), 0, 0, 0, 0, time.UTC)
+	dateB := time.Date(b.Year(), b.Month(), b.Day(), 0, 0, 0, 0, time.UTC)
+	return int(dateB.Sub(dateA).Hours() / 24)
+}
+
+// The startOfWeek() function returns the Monday on or before t
+func startOfWeek(t time.Time) time.Time {
+	offset := (int(t.Weekday()) + 6) % 7
+	return t.AddDate(0, 0, -offset)
+}
@@ -0,0 +1,163 @@
+// Filename: internal/recurrence/recurrence_test.go
+
+package recurrence
+
+import (
+	"testing"
+	"time"
+)
+
+// The date() function returns 09:00 UTC on a day
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 9, 0, 0, 0, time.UTC)
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		rule  string
+		valid bool
+	}{
+		{"FREQ=DAILY", true},
+		{"RRULE:FREQ=weekly;byday=mo,we", true},
+		{"FREQ=MONTHLY;BYMONTHDAY=-1", true},
+		{"FREQ=YEARLY;INTERVAL=2;COUNT=5", true},
+		{"FREQ=DAILY;UNTIL=20240131T000000Z", true},
+		{"", false},
+		{"FREQ=HOURLY", false},
+		{"INTERVAL=2", false},
+		{"FREQ=DAILY;FREQ=WEEKLY", false},
+		{"FREQ=DAILY;INTERVAL=0", false},
+		{"FREQ=WEEKLY;BYDAY=XX", false},
+		{"FREQ=MONTHLY;BYMONTHDAY=0", false},
+		{"FREQ=MONTHLY;BYMONTHDAY=32", false},
+		{"FREQ=DAILY;COUNT=0", false},
+		{"FREQ=DAILY;UNTIL=2024-01-31", false},
+		{"FREQ=DAILY;COUNT=2;UNTIL=20240131", false},
+		{"FREQ=DAILY;BYSETPOS=1", false},
+		{"FREQ", false},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.rule)
+		if (err == nil) != tt.valid {
+			t.Errorf("Parse(%q) returned error %v; want valid %t", tt.rule, err, tt.valid)
+		}
+	}
+}
+
+func TestOccurrences(t *testing.T) {
+	tests := []struct {
+		name   string
+		rule   string
+		anchor time.Time
+		n      int
+		want   []time.Time
+	}{
+		{
+			name:   "last day of the month",
+			rule:   "FREQ=MONTHLY;BYMONTHDAY=-1",
+			anchor: date(2024, time.January, 31),
+			n:      1,
+			want:   []time.Time{date(2024, time.February, 29), date(2024, time.March, 31), date(2024, time.April, 30)},
+		},
+		{
+			name:   "last day of February outside a leap year",
+			rule:   "FREQ=MONTHLY;BYMONTHDAY=-1",
+			anchor: date(2023, time.January, 31),
+			n:      1,
+			want:   []time.Time{date(2023, time.February, 28), date(2023, time.March, 31)},
+		},
+		{
+			name:   "the 31st skips short months",
+			rule:   "FREQ=MONTHLY",
+			anchor: date(2023, time.January, 31),
+			n:      1,
+			want:   []time.Time{date(2023, time.March, 31), date(2023, time.May, 31), date(2023, time.July, 31)},
+		},
+		{
+			name:   "the 31st by month day skips short months",
+			rule:   "FREQ=MONTHLY;BYMONTHDAY=31",
+			anchor: date(2023, time.August, 31),
+			n:      1,
+			want:   []time.Time{date(2023, time.October, 31), date(2023, time.December, 31)},
+		},
+		{
+			name:   "February 29th only comes in leap years",
+			rule:   "FREQ=YEARLY",
+			anchor: date(2024, time.February, 29),
+			n:      1,
+			want:   []time.Time{date(2028, time.February, 29), date(2032, time.February, 29)},
+		},
+		{
+			name:   "every other week on Monday and Wednesday",
+			rule:   "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,WE",
+			anchor: date(2024, time.January, 1),
+			n:      1,
+			want:   []time.Time{date(2024, time.January, 3), date(2024, time.January, 15), date(2024, time.January, 17), date(2024, time.January, 29)},
+		},
+		{
+			name:   "every third day",
+			rule:   "FREQ=DAILY;INTERVAL=3",
+			anchor: date(2024, time.February, 27),
+			n:      1,
+			want:   []time.Time{date(2024, time.March, 1), date(2024, time.March, 4)},
+		},
+		{
+			name:   "weekly on the anchor's weekday",
+			rule:   "FREQ=WEEKLY",
+			anchor: date(2024, time.January, 5),
+			n:      1,
+			want:   []time.Time{date(2024, time.January, 12), date(2024, time.January, 19)},
+		},
+		{
+			name:   "COUNT includes the anchor",
+			rule:   "FREQ=DAILY;COUNT=3",
+			anchor: date(2024, time.January, 1),
+			n:      1,
+			want:   []time.Time{date(2024, time.January, 2), date(2024, time.January, 3)},
+		},
+		{
+			name:   "COUNT already reached",
+			rule:   "FREQ=DAILY;COUNT=3",
+			anchor: date(2024, time.January, 3),
+			n:      3,
+			want:   []time.Time{},
+		},
+		{
+			name:   "UNTIL as a date includes the whole day",
+			rule:   "FREQ=DAILY;UNTIL=20240103",
+			anchor: date(2024, time.January, 1),
+			n:      1,
+			want:   []time.Time{date(2024, time.January, 2), date(2024, time.January, 3)},
+		},
+		{
+			name:   "UNTIL as a date-time",
+			rule:   "FREQ=DAILY;UNTIL=20240103T000000Z",
+			anchor: date(2024, time.January, 1),
+			n:      1,
+			want:   []time.Time{date(2024, time.January, 2)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := Parse(tt.rule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// A series with COUNT or UNTIL is asked for one more occurrence
+			// than it has left
+			limit := len(tt.want)
+			if rule.Count > 0 || rule.Until != nil {
+				limit++
+			}
+			got := rule.Occurrences(tt.anchor, tt.n, limit)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v; want %v", got, tt.want)
+			}
+			for i := range tt.want {
+				if !got[i].Equal(tt.want[i]) {
+					t.Errorf("occurrence %d: got %s; want %s", i+1, got[i].Format(time.RFC3339), tt.want[i].Format(time.RFC3339))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,7 @@
+-- Filename: migrations/000002_add_todo_status.down.sql
+
+ALTER TABLE todos ADD COLUMN IF NOT EXISTS complete text NOT NULL DEFAULT 'NO';
+UPDATE todos SET complete = 'YES' WHERE status = 'done';
+ALTER TABLE todos DROP CONSTRAINT IF EXISTS todos_status_check;
+ALTER TABLE todos DROP COLUMN IF EXISTS completed_at;
+ALTER TABLE todos DROP COLUMN IF EXISTS status;
@@ -0,0 +1,12 @@
+-- Filename: migrations/000002_add_todo_status.up.sql
+
+ALTER TABLE todos ADD COLUMN IF NOT EXISTS status text NOT NULL DEFAULT 'todo';
+ALTER TABLE todos ADD COLUMN IF NOT EXISTS completed_at timestamp(0) with time zone;
+
+-- Map the old free-text values onto the new lifecycle
+UPDATE todos SET status = 'done', completed_at = created_at WHERE upper(complete) = 'YES';
+UPDATE todos SET status = 'todo' WHERE upper(complete) <> 'YES';
+
+ALTER TABLE todos ADD CONSTRAINT todos_status_check
+    CHECK (status IN ('todo', 'in_progress', 'blocked', 'done', 'cancelled'));
+ALTER TABLE todos DROP COLUMN IF EXISTS complete;
@@ -0,0 +1,6 @@
+-- Filename: migrations/000003_add_todo_dates.down.sql
+
+DROP INDEX IF EXISTS todos_due_at_idx;
+ALTER TABLE todos DROP CONSTRAINT IF EXISTS todos_start_before_due_check;
+ALTER TABLE todos DROP COLUMN IF EXISTS due_at;
+ALTER TABLE todos DROP COLUMN IF EXISTS start_at;
@@ -0,0 +1,9 @@
+-- Filename: migrations/000003_add_todo_dates.up.sql
+
+ALTER TABLE todos ADD COLUMN IF NOT EXISTS start_at timestamp(0) with time zone;
+ALTER TABLE todos ADD COLUMN IF NOT EXISTS due_at timestamp(0) with time zone;
+
+ALTER TABLE todos ADD CONSTRAINT todos_start_before_due_check
+    CHECK (start_at IS NULL OR due_at IS NULL OR start_at <= due_at);
+
+CREATE INDEX IF NOT EXISTS todos_due_at_idx ON todos (due_at);
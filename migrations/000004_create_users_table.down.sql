@@ -0,0 +1,5 @@
+-- Filename: migrations/000004_create_users_table.down.sql
+
+DROP INDEX IF EXISTS todos_user_id_idx;
+ALTER TABLE todos DROP COLUMN IF EXISTS user_id;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,18 @@
+-- Filename: migrations/000004_create_users_table.up.sql
+
+CREATE EXTENSION IF NOT EXISTS citext;
+
+CREATE TABLE IF NOT EXISTS users (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    name text NOT NULL,
+    email citext UNIQUE NOT NULL,
+    password_hash bytea NOT NULL,
+    version integer NOT NULL DEFAULT 1
+);
+
+-- Todos created before accounts existed have no owner. They are kept, as
+-- there is no way to tell whose they were, but every query on todos is
+-- limited to a user so they are never shown, changed or purged
+ALTER TABLE todos ADD COLUMN IF NOT EXISTS user_id bigint REFERENCES users ON DELETE CASCADE;
+CREATE INDEX IF NOT EXISTS todos_user_id_idx ON todos (user_id);
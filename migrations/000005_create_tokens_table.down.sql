@@ -0,0 +1,3 @@
+-- Filename: migrations/000005_create_tokens_table.down.sql
+
+DROP TABLE IF EXISTS tokens;
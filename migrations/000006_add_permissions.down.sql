@@ -0,0 +1,4 @@
+-- Filename: migrations/000006_add_permissions.down.sql
+
+DROP TABLE IF EXISTS users_permissions;
+DROP TABLE IF EXISTS permissions;
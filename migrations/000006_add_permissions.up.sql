@@ -0,0 +1,23 @@
+-- Filename: migrations/000006_add_permissions.up.sql
+
+CREATE TABLE IF NOT EXISTS permissions (
+    id bigserial PRIMARY KEY,
+    code text NOT NULL UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS users_permissions (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    permission_id bigint NOT NULL REFERENCES permissions ON DELETE CASCADE,
+    PRIMARY KEY (user_id, permission_id)
+);
+
+INSERT INTO permissions (code)
+VALUES
+    ('todos:read'),
+    ('todos:write')
+ON CONFLICT DO NOTHING;
+
+-- Existing users keep full access to their own todos
+INSERT INTO users_permissions
+SELECT users.id, permissions.id FROM users CROSS JOIN permissions
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,5 @@
+-- Filename: migrations/000008_create_lists_table.down.sql
+
+DROP INDEX IF EXISTS todos_list_id_idx;
+ALTER TABLE todos DROP COLUMN IF EXISTS list_id;
+DROP TABLE IF EXISTS lists;
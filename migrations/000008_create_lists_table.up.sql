@@ -0,0 +1,19 @@
+-- Filename: migrations/000008_create_lists_table.up.sql
+
+CREATE TABLE IF NOT EXISTS lists (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    name text NOT NULL,
+    is_inbox boolean NOT NULL DEFAULT false,
+    -- Deleted lists are kept until their trashed todos are gone, so that
+    -- a restored todo goes back into the list it was deleted from
+    deleted_at timestamp(0) with time zone,
+    version integer NOT NULL DEFAULT 1
+);
+
+-- Each user has at most one inbox list
+CREATE UNIQUE INDEX IF NOT EXISTS lists_user_id_inbox_idx ON lists (user_id) WHERE is_inbox;
+
+ALTER TABLE todos ADD COLUMN IF NOT EXISTS list_id bigint REFERENCES lists ON DELETE SET NULL;
+CREATE INDEX IF NOT EXISTS todos_list_id_idx ON todos (list_id);
@@ -0,0 +1,5 @@
+-- Filename: migrations/000009_add_todo_parent.down.sql
+
+DROP INDEX IF EXISTS todos_parent_id_idx;
+ALTER TABLE todos DROP CONSTRAINT IF EXISTS todos_parent_not_self_check;
+ALTER TABLE todos DROP COLUMN IF EXISTS parent_id;
@@ -0,0 +1,5 @@
+-- Filename: migrations/000009_add_todo_parent.up.sql
+
+ALTER TABLE todos ADD COLUMN IF NOT EXISTS parent_id bigint REFERENCES todos ON DELETE CASCADE;
+ALTER TABLE todos ADD CONSTRAINT todos_parent_not_self_check CHECK (parent_id <> id);
+CREATE INDEX IF NOT EXISTS todos_parent_id_idx ON todos (parent_id);
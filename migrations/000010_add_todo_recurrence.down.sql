@@ -0,0 +1,6 @@
+-- Filename: migrations/000010_add_todo_recurrence.down.sql
+
+ALTER TABLE todos DROP COLUMN IF EXISTS next_todo_id;
+ALTER TABLE todos DROP COLUMN IF EXISTS next_occurrence_at;
+ALTER TABLE todos DROP COLUMN IF EXISTS occurrence;
+ALTER TABLE todos DROP COLUMN IF EXISTS recurrence;
@@ -0,0 +1,8 @@
+-- Filename: migrations/000010_add_todo_recurrence.up.sql
+
+ALTER TABLE todos ADD COLUMN IF NOT EXISTS recurrence text NOT NULL DEFAULT '';
+ALTER TABLE todos ADD COLUMN IF NOT EXISTS occurrence integer NOT NULL DEFAULT 1;
+ALTER TABLE todos ADD COLUMN IF NOT EXISTS next_occurrence_at timestamp(0) with time zone;
+-- The occurrence that was created when a recurring todo was completed, so
+-- that completing it again after reopening does not create another one
+ALTER TABLE todos ADD COLUMN IF NOT EXISTS next_todo_id bigint REFERENCES todos ON DELETE SET NULL;
@@ -0,0 +1,4 @@
+-- Filename: migrations/000011_add_todo_priority.down.sql
+
+ALTER TABLE todos DROP CONSTRAINT IF EXISTS todos_priority_check;
+ALTER TABLE todos DROP COLUMN IF EXISTS priority;
@@ -0,0 +1,4 @@
+-- Filename: migrations/000011_add_todo_priority.up.sql
+
+ALTER TABLE todos ADD COLUMN IF NOT EXISTS priority smallint NOT NULL DEFAULT 0;
+ALTER TABLE todos ADD CONSTRAINT todos_priority_check CHECK (priority BETWEEN 0 AND 4);
@@ -0,0 +1,4 @@
+-- Filename: migrations/000012_add_todo_deleted_at.down.sql
+
+DROP INDEX IF EXISTS todos_deleted_at_idx;
+ALTER TABLE todos DROP COLUMN IF EXISTS deleted_at;
@@ -0,0 +1,4 @@
+-- Filename: migrations/000012_add_todo_deleted_at.up.sql
+
+ALTER TABLE todos ADD COLUMN IF NOT EXISTS deleted_at timestamp(0) with time zone;
+CREATE INDEX IF NOT EXISTS todos_deleted_at_idx ON todos (deleted_at) WHERE deleted_at IS NOT NULL;
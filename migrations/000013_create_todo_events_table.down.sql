@@ -0,0 +1,3 @@
+-- Filename: migrations/000013_create_todo_events_table.down.sql
+
+DROP TABLE IF EXISTS todo_events;
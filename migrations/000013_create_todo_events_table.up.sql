@@ -0,0 +1,17 @@
+-- Filename: migrations/000013_create_todo_events_table.up.sql
+
+-- Events keep no foreign key to todos so that history outlives a purge
+CREATE TABLE IF NOT EXISTS todo_events (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(6) with time zone NOT NULL DEFAULT NOW(),
+    todo_id bigint NOT NULL,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    event_type text NOT NULL,
+    old_values jsonb,
+    new_values jsonb,
+    version integer NOT NULL,
+    actor_id bigint REFERENCES users ON DELETE SET NULL,
+    request_id text NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS todo_events_todo_id_idx ON todo_events (todo_id, created_at);
@@ -0,0 +1,5 @@
+-- Filename: migrations/000014_add_todo_search_vector.down.sql
+
+DROP INDEX IF EXISTS todos_search_english_idx;
+DROP INDEX IF EXISTS todos_search_vector_idx;
+ALTER TABLE todos DROP COLUMN IF EXISTS search_vector;
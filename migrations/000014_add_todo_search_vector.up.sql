@@ -0,0 +1,6 @@
+-- Filename: migrations/000014_add_todo_search_vector.up.sql
+
+ALTER TABLE todos ADD COLUMN IF NOT EXISTS search_vector tsvector
+    GENERATED ALWAYS AS (to_tsvector('simple', task)) STORED;
+CREATE INDEX IF NOT EXISTS todos_search_vector_idx ON todos USING GIN (search_vector);
+CREATE INDEX IF NOT EXISTS todos_search_english_idx ON todos USING GIN (to_tsvector('english', task));
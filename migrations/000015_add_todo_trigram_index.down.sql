@@ -0,0 +1,3 @@
+-- Filename: migrations/000015_add_todo_trigram_index.down.sql
+
+DROP INDEX IF EXISTS todos_task_trgm_idx;
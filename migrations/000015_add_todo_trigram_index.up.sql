@@ -0,0 +1,5 @@
+-- Filename: migrations/000015_add_todo_trigram_index.up.sql
+
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX IF NOT EXISTS todos_task_trgm_idx ON todos USING GIN (task gin_trgm_ops);
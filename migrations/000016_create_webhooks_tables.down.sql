@@ -0,0 +1,5 @@
+-- Filename: migrations/000016_create_webhooks_tables.down.sql
+
+DROP TABLE IF EXISTS webhook_delivery_attempts;
+DROP TABLE IF EXISTS webhook_deliveries;
+DROP TABLE IF EXISTS webhooks;
@@ -0,0 +1,5 @@
+-- Filename: migrations/000017_create_outbox_table.down.sql
+
+DROP TABLE IF EXISTS outbox;
+DROP FUNCTION IF EXISTS set_outbox_position();
+DROP SEQUENCE IF EXISTS outbox_position_seq;
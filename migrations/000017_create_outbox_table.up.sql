@@ -0,0 +1,38 @@
+-- Filename: migrations/000017_create_outbox_table.up.sql
+
+-- Rows are written in the same transaction as the change they describe
+-- and are relayed to the sinks in position order. A relay claims a batch
+-- of messages until claimed_until and sends them outside of any
+-- transaction. A claim that runs out is picked up again
+CREATE SEQUENCE IF NOT EXISTS outbox_position_seq;
+
+CREATE TABLE IF NOT EXISTS outbox (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(6) with time zone NOT NULL DEFAULT NOW(),
+    topic text NOT NULL,
+    key text NOT NULL,
+    payload jsonb NOT NULL,
+    position bigint UNIQUE,
+    claimed_until timestamp(6) with time zone,
+    dispatched_at timestamp(6) with time zone
+);
+
+CREATE INDEX IF NOT EXISTS outbox_pending_idx ON outbox (position) WHERE dispatched_at IS NULL;
+
+-- Ids are handed out as rows are inserted, which is not the order their
+-- transactions commit in. The position is taken while the transaction
+-- commits and holds a lock until it has, so a relay never passes over a
+-- message that commits after the ones it has already sent
+CREATE OR REPLACE FUNCTION set_outbox_position() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_advisory_xact_lock(7311011800240003);
+    UPDATE outbox SET position = nextval('outbox_position_seq') WHERE id = NEW.id;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS outbox_position_trigger ON outbox;
+CREATE CONSTRAINT TRIGGER outbox_position_trigger
+    AFTER INSERT ON outbox
+    DEFERRABLE INITIALLY DEFERRED
+    FOR EACH ROW EXECUTE FUNCTION set_outbox_position();
@@ -0,0 +1,7 @@
+-- Filename: migrations/000018_add_todo_event_position.down.sql
+
+DROP TRIGGER IF EXISTS todo_events_position_trigger ON todo_events;
+DROP FUNCTION IF EXISTS set_todo_event_position();
+DROP INDEX IF EXISTS todo_events_position_idx;
+ALTER TABLE todo_events DROP COLUMN IF EXISTS position;
+DROP SEQUENCE IF EXISTS todo_events_position_seq;
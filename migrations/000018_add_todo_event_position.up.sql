@@ -0,0 +1,33 @@
+-- Filename: migrations/000018_add_todo_event_position.up.sql
+
+-- Ids are handed out as events are inserted, which is not the order their
+-- transactions commit in. The position is taken while the transaction
+-- commits and holds a lock until it has, so positions become visible in
+-- order and readers can resume from the last one they saw
+CREATE SEQUENCE IF NOT EXISTS todo_events_position_seq;
+
+ALTER TABLE todo_events ADD COLUMN IF NOT EXISTS position bigint;
+
+-- Events that have already been committed keep their order
+UPDATE todo_events SET position = id WHERE position IS NULL;
+SELECT setval('todo_events_position_seq', COALESCE((SELECT MAX(position) FROM todo_events), 0) + 1, false);
+
+CREATE UNIQUE INDEX IF NOT EXISTS todo_events_position_idx ON todo_events (position);
+
+CREATE OR REPLACE FUNCTION set_todo_event_position() RETURNS trigger AS $$
+BEGIN
+    -- Only one transaction at a time takes positions, and the lock is held
+    -- until that transaction has committed
+    PERFORM pg_advisory_xact_lock(7311011800240002);
+    UPDATE todo_events SET position = nextval('todo_events_position_seq') WHERE id = NEW.id;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+-- A deferred trigger runs at commit time, after every other statement in
+-- the transaction
+DROP TRIGGER IF EXISTS todo_events_position_trigger ON todo_events;
+CREATE CONSTRAINT TRIGGER todo_events_position_trigger
+    AFTER INSERT ON todo_events
+    DEFERRABLE INITIALLY DEFERRED
+    FOR EACH ROW EXECUTE FUNCTION set_todo_event_position();